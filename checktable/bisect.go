@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/astaxie/beego/logs"
+	"github.com/forest11/checktable/dbutil"
+)
+
+//bisectChunk checksum不一致的chunk按行数对半分割, 只对比checksum仍然不一致的部分, 行数不超过bisectRows时才读取全部行
+func bisectChunk(stbInfo, dtbInfo *TableInfo, chunk chunkInfo, cs Checksummer) ([]*rowDiff, error) {
+	sCnt, err := stbInfo.GetRangeRowCount(chunk)
+	if err != nil {
+		return nil, err
+	}
+	dCnt, err := dtbInfo.GetRangeRowCount(chunk)
+	if err != nil {
+		return nil, err
+	}
+	rows := getMax(sCnt, dCnt)
+	if rows <= bisectRows {
+		return DiffRowData(stbInfo, dtbInfo, chunk)
+	}
+
+	// 以行数多的一边的中间行作为分割点, 分片合并时使用目标表
+	t, cnt := stbInfo, sCnt
+	if dCnt > sCnt || len(stbInfo.shards) > 0 {
+		t, cnt = dtbInfo, dCnt
+	}
+	if cnt < 2 {
+		// 读取的一边不能再分割, 例如分片合并时目标表缺失chunk中的大部分行
+		return DiffRowData(stbInfo, dtbInfo, chunk)
+	}
+	q, release := t.querier()
+	// 只在chunk的范围内读取, 分割点不会超出chunk
+	mid, err := dbutil.GetLimitPk(q, t.dbName, t.tableName, t.pkCols, t.where, chunk.lower, chunk.upper, cnt/2)
+	release()
+	if err != nil {
+		return nil, err
+	}
+	if mid == nil || (len(mid) == len(chunk.upper) && pkValue(mid).String() == pkValue(chunk.upper).String()) {
+		// 分割点不在chunk内部时前一半与chunk相同, 不再分割
+		return DiffRowData(stbInfo, dtbInfo, chunk)
+	}
+	logs.Debug("bisect chunk %s at %s, rows: %d", chunk, pkValue(mid), rows)
+
+	var diffs []*rowDiff
+	for _, half := range []chunkInfo{newChunkInfo(chunk.lower, mid), newChunkInfo(mid, chunk.upper)} {
+		half.id = chunk.id
+		equal, err := compareCheckSum(stbInfo, dtbInfo, half, cs)
+		if err != nil {
+			return nil, err
+		}
+		if equal {
+			continue
+		}
+		halfDiffs, err := bisectChunk(stbInfo, dtbInfo, half, cs)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, halfDiffs...)
+	}
+	return diffs, nil
+}
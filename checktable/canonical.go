@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//selectCols 生成查询对比字段的表达式, 规范化时timestamp读取为UTC的时间戳, 与连接的时区无关
+func (t *TableInfo) selectCols() string {
+	exprs := make([]string, 0, len(t.columns))
+	for _, c := range t.columns {
+		if t.canonical && c.dataType == "timestamp" {
+			exprs = append(exprs, fmt.Sprintf("UNIX_TIMESTAMP(%s)", c.expr))
+			continue
+		}
+		exprs = append(exprs, c.expr)
+	}
+	return strings.Join(exprs, ",")
+}
+
+//canonicalRow 把对比字段的值转换为规范化的字符串, NULL保持为nil
+func (t *TableInfo) canonicalRow(vals []interface{}) {
+	if !t.canonical {
+		return
+	}
+	for i, v := range vals {
+		if v != nil {
+			vals[i] = canonicalValue(v, t.columns[i].dataType)
+		}
+	}
+}
+
+//canonicalValue 按字段类型规范化, 两边的驱动协议和数据库版本不同时得到相同的字符串
+func canonicalValue(v interface{}, dataType string) string {
+	var s string
+	switch x := v.(type) {
+	case time.Time:
+		s = x.UTC().Format("2006-01-02 15:04:05.999999999")
+	case []byte:
+		s = string(x)
+	default:
+		s = fmt.Sprintf("%v", x)
+	}
+
+	switch dataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "year":
+		if i, ok := new(big.Int).SetString(s, 10); ok {
+			return i.String()
+		}
+	case "decimal":
+		// 小数末尾的0不影响值, 1.50与1.5相同
+		if r, ok := new(big.Rat).SetString(s); ok {
+			return decimalString(r, fracDigits(s))
+		}
+	case "timestamp":
+		// UNIX_TIMESTAMP的秒数, 输出为UTC时间
+		if r, ok := new(big.Rat).SetString(s); ok {
+			sec, nsec := r.FloatString(9), int64(0)
+			if i := strings.Index(sec, "."); i >= 0 {
+				nsec, _ = strconv.ParseInt(sec[i+1:], 10, 64)
+				sec = sec[:i]
+			}
+			if n, err := strconv.ParseInt(sec, 10, 64); err == nil {
+				return time.Unix(n, nsec).UTC().Format("2006-01-02 15:04:05.999999999")
+			}
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(s, 32); err == nil {
+			return strconv.FormatFloat(f, 'g', -1, 32)
+		}
+	case "double":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return strconv.FormatFloat(f, 'g', -1, 64)
+		}
+	}
+	return s
+}
+
+//fracDigits 数值字符串的小数位数
+func fracDigits(s string) int {
+	i := strings.Index(s, ".")
+	if i < 0 {
+		return 0
+	}
+	n := 0
+	for _, c := range s[i+1:] {
+		if c < '0' || c > '9' {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+//decimalString 输出prec位小数, 去掉末尾的0
+func decimalString(r *big.Rat, prec int) string {
+	s := r.FloatString(prec)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(s, "0")
+		s = strings.TrimSuffix(s, ".")
+	}
+	return s
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanonicalValue(t *testing.T) {
+	tests := []struct {
+		v        interface{}
+		dataType string
+		want     string
+	}{
+		{"1.50", "decimal", "1.5"},
+		{[]byte("1.500"), "decimal", "1.5"},
+		{"100.00", "decimal", "100"},
+		{"-0.00", "decimal", "0"},
+		{"-12.340", "decimal", "-12.34"},
+		{"0.000001", "decimal", "0.000001"},
+		{"12345678901234567890.123456789", "decimal", "12345678901234567890.123456789"},
+		{"1609459200", "timestamp", "2021-01-01 00:00:00"},
+		{"1609459200.000000", "timestamp", "2021-01-01 00:00:00"},
+		{"1609459200.500000", "timestamp", "2021-01-01 00:00:00.5"},
+		{[]byte("1609459200.123456"), "timestamp", "2021-01-01 00:00:00.123456"},
+		{"007", "int", "7"},
+		{"1.5", "double", "1.5"},
+		{time.Date(2021, 1, 1, 8, 0, 0, 0, time.FixedZone("CST", 8*3600)), "datetime", "2021-01-01 00:00:00"},
+		{"abc", "varchar", "abc"},
+	}
+	for _, tt := range tests {
+		if got := canonicalValue(tt.v, tt.dataType); got != tt.want {
+			t.Errorf("%v %s: got %q, want %q", tt.v, tt.dataType, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+const (
+	chunkPending = "pending"
+	chunkEqual   = "equal"
+	chunkDiff    = "different"
+	chunkError   = "error"
+)
+
+//checkpointDiff 保存到checkpoint的不一致行
+type checkpointDiff struct {
+	PK  pkValue  `json:"pk"`
+	Row *rowDiff `json:"row"`
+}
+
+//chunkState chunk的校验状态
+type chunkState struct {
+	Lower  pkValue          `json:"lower"`
+	Upper  pkValue          `json:"upper"`
+	Status string           `json:"status"`
+	Error  string           `json:"error,omitempty"`
+	Diffs  []checkpointDiff `json:"diffs,omitempty"`
+}
+
+//tableState 单表的校验状态
+type tableState struct {
+	Strategy string        `json:"strategy"`
+	Checksum string        `json:"checksum,omitempty"`
+	PkCols   []string      `json:"pk_cols"`
+	Planned  bool          `json:"planned"` // chunk已经分割完成
+	Chunks   []*chunkState `json:"chunks"`
+
+	Done       bool             `json:"done"`
+	Status     string           `json:"status,omitempty"`
+	Insert     int              `json:"insert"`
+	Update     int              `json:"update"`
+	Delete     int              `json:"delete"`
+	Resolved   int              `json:"resolved"`
+	Error      string           `json:"error,omitempty"`
+	Diffs      []checkpointDiff `json:"diffs,omitempty"` // 重新对比后最终不一致的行
+	Schema     []schemaDiff     `json:"schema,omitempty"`
+	ChunkSizes *chunkSizeStats  `json:"chunk_sizes,omitempty"`
+}
+
+//checkpoint 校验进度, 中断后可以用-resume继续未完成的chunk
+type checkpoint struct {
+	sync.Mutex
+	file     string
+	interval time.Duration
+	lastSave time.Time
+	dirty    bool
+	Tables   map[string]*tableState `json:"tables"`
+}
+
+var ckpt *checkpoint
+
+//newCheckpoint 创建checkpoint, resume为true时从文件中恢复, file为空时不保存
+func newCheckpoint(file string, interval time.Duration, resume bool) (*checkpoint, error) {
+	c := &checkpoint{
+		file:     file,
+		interval: interval,
+		lastSave: time.Now(),
+		Tables:   make(map[string]*tableState),
+	}
+	if file == "" || !resume {
+		return c, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		logs.Warn("checkpoint %s not exists, start a new check", file)
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("load checkpoint %s err: %v", file, err)
+	}
+	if c.Tables == nil {
+		c.Tables = make(map[string]*tableState)
+	}
+	return c, nil
+}
+
+func tableStateKey(p tablePair) string {
+	return fmt.Sprintf("%s=>%s", p.sTable, p.dTable)
+}
+
+//Table 获取单表的状态, 主键与checkpoint中不一致时重新校验
+func (c *checkpoint) Table(p tablePair, strategy string, pkCols []string) *tableState {
+	c.Lock()
+	defer c.Unlock()
+
+	k := tableStateKey(p)
+	ts, ok := c.Tables[k]
+	if ok && ts.Strategy == strategy && strings.Join(ts.PkCols, ",") == strings.Join(pkCols, ",") {
+		return ts
+	}
+	if ok {
+		logs.Warn("%s key changed, checkpoint discarded", k)
+	}
+	ts = &tableState{Strategy: strategy, PkCols: pkCols}
+	c.Tables[k] = ts
+	c.dirty = true
+	return ts
+}
+
+//FinishedTable 获取已经完成的表
+func (c *checkpoint) FinishedTable(p tablePair) *tableState {
+	c.Lock()
+	defer c.Unlock()
+	ts, ok := c.Tables[tableStateKey(p)]
+	if !ok || !ts.Done {
+		return nil
+	}
+	return ts
+}
+
+//ResetPlan 重新分割chunk, 清空没有分割完成的chunk
+func (c *checkpoint) ResetPlan(ts *tableState) {
+	c.Lock()
+	ts.Chunks = make([]*chunkState, 0)
+	ts.Planned = false
+	c.dirty = true
+	c.Unlock()
+}
+
+//AddChunk 保存分割出的chunk, 返回chunk的id
+func (c *checkpoint) AddChunk(ts *tableState, chunk chunkInfo) int {
+	c.Lock()
+	defer c.Unlock()
+	ts.Chunks = append(ts.Chunks, &chunkState{Lower: chunk.lower, Upper: chunk.upper, Status: chunkPending})
+	c.dirty = true
+	return len(ts.Chunks) - 1
+}
+
+//FinishPlan chunk分割完成, 中断后直接使用保存的chunk
+func (c *checkpoint) FinishPlan(ts *tableState) {
+	c.Lock()
+	ts.Planned = true
+	c.dirty = true
+	c.Unlock()
+	c.Save(true)
+}
+
+//Plan 返回保存的chunk和状态
+func (c *checkpoint) Plan(ts *tableState) ([]chunkInfo, []string) {
+	c.Lock()
+	defer c.Unlock()
+	chunks := make([]chunkInfo, 0, len(ts.Chunks))
+	status := make([]string, 0, len(ts.Chunks))
+	for i, cs := range ts.Chunks {
+		chunk := newChunkInfo(cs.Lower, cs.Upper)
+		chunk.id = i
+		chunks = append(chunks, chunk)
+		status = append(status, cs.Status)
+	}
+	return chunks, status
+}
+
+//SetChunk 更新chunk的状态
+func (c *checkpoint) SetChunk(ts *tableState, id int, status string, diffs []*rowDiff, err error) {
+	c.Lock()
+	if id >= 0 && id < len(ts.Chunks) {
+		cs := ts.Chunks[id]
+		cs.Status = status
+		cs.Error = ""
+		if err != nil {
+			cs.Error = err.Error()
+		}
+		cs.Diffs = make([]checkpointDiff, 0, len(diffs))
+		for _, d := range diffs {
+			cs.Diffs = append(cs.Diffs, checkpointDiff{PK: d.pk, Row: d})
+		}
+		c.dirty = true
+	}
+	c.Unlock()
+	c.Save(false)
+}
+
+//ChunkDiffs 返回chunk之前找到的不一致行
+func (c *checkpoint) ChunkDiffs(ts *tableState, id int) []*rowDiff {
+	c.Lock()
+	defer c.Unlock()
+	var diffs []*rowDiff
+	for _, d := range ts.Chunks[id].Diffs {
+		d.Row.pk = d.PK
+		d.Row.keyCols = ts.PkCols
+		diffs = append(diffs, d.Row)
+	}
+	return diffs
+}
+
+//TableDiffs 返回已完成的表所有不一致的行
+func (c *checkpoint) TableDiffs(ts *tableState) []*rowDiff {
+	c.Lock()
+	defer c.Unlock()
+	var diffs []*rowDiff
+	for _, d := range ts.Diffs {
+		d.Row.pk = d.PK
+		d.Row.keyCols = ts.PkCols
+		diffs = append(diffs, d.Row)
+	}
+	return diffs
+}
+
+//ChunkErrors 校验失败的chunk数
+func (c *checkpoint) ChunkErrors(ts *tableState) int {
+	c.Lock()
+	defer c.Unlock()
+	return ts.chunkErrors()
+}
+
+func (ts *tableState) chunkErrors() int {
+	var n int
+	for _, cs := range ts.Chunks {
+		if cs.Status == chunkError {
+			n++
+		}
+	}
+	return n
+}
+
+//FinishTable 保存单表的校验结果, 有chunk校验失败时不标记为完成, -resume时重新校验失败的chunk
+func (c *checkpoint) FinishTable(ts *tableState, r *tableResult) {
+	c.Lock()
+	ts.Done = ts.chunkErrors() == 0
+	ts.Checksum = r.checksum
+	ts.Schema = r.schema
+	ts.ChunkSizes = r.chunkSizes
+	ts.Status = r.status
+	ts.Insert, ts.Update, ts.Delete, ts.Resolved = r.insert, r.update, r.delete, r.resolved
+	ts.Diffs = make([]checkpointDiff, 0, len(r.rows))
+	for _, d := range r.rows {
+		ts.Diffs = append(ts.Diffs, checkpointDiff{PK: d.pk, Row: d})
+	}
+	ts.Error = ""
+	if r.err != nil {
+		ts.Error = r.err.Error()
+	}
+	c.dirty = true
+	c.Unlock()
+	c.Save(true)
+}
+
+//Save 保存到文件, force为false时按间隔保存
+func (c *checkpoint) Save(force bool) {
+	c.Lock()
+	defer c.Unlock()
+	if c.file == "" || !c.dirty {
+		return
+	}
+	if !force && time.Since(c.lastSave) < c.interval {
+		return
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		logs.Error("marshal checkpoint err: %v", err)
+		return
+	}
+	// 先写临时文件再重命名, 避免进程被杀时文件不完整
+	tmp := c.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		logs.Error("write checkpoint err: %v", err)
+		return
+	}
+	if err = os.Rename(tmp, c.file); err != nil {
+		logs.Error("rename checkpoint err: %v", err)
+		return
+	}
+	c.lastSave = time.Now()
+	c.dirty = false
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFinishTableWithChunkErrors(t *testing.T) {
+	c, err := newCheckpoint("", time.Second, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := tablePair{sTable: "t1", dTable: "t1"}
+	ts := c.Table(p, "pk", []string{"id"})
+	a := c.AddChunk(ts, newChunkInfo(nil, []interface{}{10}))
+	b := c.AddChunk(ts, newChunkInfo([]interface{}{10}, nil))
+	c.SetChunk(ts, a, chunkEqual, nil, nil)
+	c.SetChunk(ts, b, chunkError, nil, errors.New("timeout"))
+
+	if n := c.ChunkErrors(ts); n != 1 {
+		t.Errorf("chunk errors: got %d, want 1", n)
+	}
+	c.FinishTable(ts, &tableResult{status: statusError})
+	if c.FinishedTable(p) != nil {
+		t.Error("table with failed chunks should not be finished")
+	}
+
+	// -resume重新校验失败的chunk后完成
+	c.SetChunk(ts, b, chunkEqual, nil, nil)
+	c.FinishTable(ts, &tableResult{status: statusEqual})
+	if c.FinishedTable(p) == nil {
+		t.Error("table should be finished after failed chunks are checked again")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/cespare/xxhash/v2"
+	"github.com/forest11/checktable/dbutil"
+)
+
+const (
+	checksumAuto   = "auto"
+	checksumCRC32  = "crc32"
+	checksumMD5    = "md5"
+	checksumSHA2   = "sha2"
+	checksumAdmin  = "admin"
+	checksumXXHash = "xxhash"
+)
+
+//Checksummer 计算chunk的校验值, 两边使用同一种算法, 结果与行的顺序无关
+type Checksummer interface {
+	Name() string
+	Checksum(t *TableInfo, chunk chunkInfo) (string, error)
+}
+
+//tableChecksummer 可以直接计算全表校验值的算法, 全表一致时不再分割chunk
+type tableChecksummer interface {
+	TableChecksum(t *TableInfo) (string, error)
+}
+
+//newChecksummer 按配置选择算法, auto时tidb使用crc32, 其他使用md5
+func newChecksummer(name string, stb, dtb *TableInfo) (Checksummer, error) {
+	switch name {
+	case "", checksumAuto:
+		if stb.CheckDBIsTidb() || dtb.CheckDBIsTidb() {
+			return crc32Checksummer{}, nil
+		}
+		return md5Checksummer{}, nil
+	case checksumCRC32:
+		return crc32Checksummer{}, nil
+	case checksumMD5:
+		return md5Checksummer{}, nil
+	case checksumSHA2:
+		return sha2Checksummer{}, nil
+	case checksumAdmin:
+		if !stb.CheckDBIsTidb() || !dtb.CheckDBIsTidb() {
+			return nil, fmt.Errorf("checksum %s requires tidb on both sides", name)
+		}
+		if len(stb.shards) > 0 {
+			return nil, fmt.Errorf("checksum %s can not be used with merge shards", name)
+		}
+		return adminChecksummer{}, nil
+	case checksumXXHash:
+		return xxhashChecksummer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown checksum algorithm %s", name)
+	}
+}
+
+//crc32Checksummer 每行CRC32后BIT_XOR
+type crc32Checksummer struct{}
+
+//Name 算法名称
+func (crc32Checksummer) Name() string { return checksumCRC32 }
+
+//Checksum 计算chunk的校验值
+func (crc32Checksummer) Checksum(t *TableInfo, chunk chunkInfo) (string, error) {
+	where, args := chunk.where(t.pkCols)
+	return t.GetCrc32CheckSum(where, args)
+}
+
+//md5Checksummer 每行md5分为两段64位后分别BIT_XOR
+type md5Checksummer struct{}
+
+//Name 算法名称
+func (md5Checksummer) Name() string { return checksumMD5 }
+
+//Checksum 计算chunk的校验值
+func (md5Checksummer) Checksum(t *TableInfo, chunk chunkInfo) (string, error) {
+	where, args := chunk.where(t.pkCols)
+	return t.GetMd5CheckSum(where, args)
+}
+
+//sha2Checksummer 每行sha256取前128位分为两段后分别BIT_XOR
+type sha2Checksummer struct{}
+
+//Name 算法名称
+func (sha2Checksummer) Name() string { return checksumSHA2 }
+
+//Checksum 计算chunk的校验值
+func (sha2Checksummer) Checksum(t *TableInfo, chunk chunkInfo) (string, error) {
+	where, args := chunk.where(t.pkCols)
+	return t.GetSha2CheckSum(where, args)
+}
+
+//adminChecksummer 先使用tidb的ADMIN CHECKSUM TABLE对比全表, 不一致时按chunk使用crc32
+type adminChecksummer struct {
+	crc32Checksummer
+}
+
+//Name 算法名称
+func (adminChecksummer) Name() string { return checksumAdmin }
+
+//TableChecksum 计算全表的校验值, 结果包含kv的crc64、kv数量和大小
+//校验的kv包含table id, 只有两边table id相同时(例如br恢复的集群)才会一致
+func (adminChecksummer) TableChecksum(t *TableInfo) (string, error) {
+	query := fmt.Sprintf("ADMIN CHECKSUM TABLE `%s`.`%s`", t.dbName, t.tableName)
+	logs.Debug("admin checksum query: %v", query)
+
+	q, release := t.querier()
+	defer release()
+	var dbName, tableName string
+	var crc64, totalKvs, totalBytes uint64
+	err := q.QueryRow(query).Scan(&dbName, &tableName, &crc64, &totalKvs, &totalBytes)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x:%d:%d", crc64, totalKvs, totalBytes), nil
+}
+
+//xxhashChecksummer 读取chunk的所有行, 规范化后在程序中计算每行的xxhash后异或, 不依赖数据库的函数和排序规则
+type xxhashChecksummer struct{}
+
+//Name 算法名称
+func (xxhashChecksummer) Name() string { return checksumXXHash }
+
+//Checksum 计算chunk的校验值, 结果为 行数:异或值
+func (xxhashChecksummer) Checksum(t *TableInfo, chunk chunkInfo) (string, error) {
+	where, args := t.chunkWhere(chunk)
+	query := fmt.Sprintf("select %s from `%s`.`%s` where %s", t.selectCols(), t.dbName, t.tableName, where)
+	logs.Debug("xxhash query: %v", query)
+
+	q, release := t.querier()
+	defer release()
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var count, sum uint64
+	h := xxhash.New()
+	for rows.Next() {
+		vals, err := dbutil.ScanRowValues(rows, len(t.columns))
+		if err != nil {
+			return "", err
+		}
+		t.canonicalRow(vals)
+		h.Reset()
+		writeRowHash(h, vals)
+		sum ^= h.Sum64()
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%016x", count, sum), nil
+}
+
+//writeRowHash 写入规范化后的行, 每个值前写入是否为NULL和长度, 避免不同的行拼接后相同
+func writeRowHash(h *xxhash.Digest, vals []interface{}) {
+	var buf [8]byte
+	for _, v := range vals {
+		if v == nil {
+			h.Write([]byte{0})
+			continue
+		}
+		s := fmt.Sprintf("%v", v)
+		h.Write([]byte{1})
+		binary.BigEndian.PutUint64(buf[:], uint64(len(s)))
+		h.Write(buf[:])
+		h.WriteString(s)
+	}
+}
+
+//compareTableCheckSum 对比两边全表的校验值
+func compareTableCheckSum(tc tableChecksummer, stb, dtb *TableInfo) (bool, error) {
+	sCheckSum, err := tc.TableChecksum(stb)
+	if err != nil {
+		return false, fmt.Errorf("sCheckSum err: %v", err)
+	}
+	dCheckSum, err := tc.TableChecksum(dtb)
+	if err != nil {
+		return false, fmt.Errorf("dCheckSum err: %v", err)
+	}
+	if sCheckSum != dCheckSum {
+		logs.Warn("%s.%s table checksum is diff, sCheckSum: %s dCheckSum: %s", stb.dbName, stb.tableName, sCheckSum, dCheckSum)
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+func rowHash(vals ...interface{}) uint64 {
+	h := xxhash.New()
+	writeRowHash(h, vals)
+	return h.Sum64()
+}
+
+func TestWriteRowHashNull(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []interface{}
+	}{
+		{"null and 'null'", []interface{}{1, nil}, []interface{}{1, "null"}},
+		{"null position", []interface{}{1, nil, "a"}, []interface{}{1, "a", nil}},
+		{"null and empty string", []interface{}{nil}, []interface{}{""}},
+		{"value boundary", []interface{}{"a#", "b"}, []interface{}{"a", "#b"}},
+	}
+	for _, tt := range tests {
+		if rowHash(tt.a...) == rowHash(tt.b...) {
+			t.Errorf("%s: %v and %v have the same hash", tt.name, tt.a, tt.b)
+		}
+	}
+	if rowHash(1, nil, "a") != rowHash(1, nil, "a") {
+		t.Errorf("same row has different hash")
+	}
+}
+
+func TestValueEqualNull(t *testing.T) {
+	tests := []struct {
+		s, d interface{}
+		want bool
+	}{
+		{nil, nil, true},
+		{nil, "null", false},
+		{"null", nil, false},
+		{nil, "", false},
+		{"null", "null", true},
+		{int64(1), "1", true},
+	}
+	for _, tt := range tests {
+		if got := valueEqual(tt.s, tt.d); got != tt.want {
+			t.Errorf("valueEqual(%#v, %#v) = %v, want %v", tt.s, tt.d, got, tt.want)
+		}
+	}
+}
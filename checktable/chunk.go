@@ -5,24 +5,44 @@ import (
 	"database/sql"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/forest11/checktable/dbutil"
 	"github.com/astaxie/beego/logs"
 )
 
-//chunkInfo 对比chunk数据
+//chunkInfo 对比chunk数据, 主键范围为(lower, upper], nil表示不限制
 type chunkInfo struct {
-	pkStart int
-	pkEnd   int
+	id    int // 在chunk列表中的下标
+	lower []interface{}
+	upper []interface{}
+	size  int // 分割时使用的chunk大小, 从checkpoint恢复或者按直方图分割时为0
 }
 
-func newChunkInfo(start, end int) chunkInfo {
+func newChunkInfo(lower, upper []interface{}) chunkInfo {
 	return chunkInfo{
-		pkStart: start,
-		pkEnd:   end,
+		lower: lower,
+		upper: upper,
 	}
 }
 
+//String 输出为 (lower, upper], 不限制时为-inf/+inf
+func (c chunkInfo) String() string {
+	lower, upper := "-inf", "+inf"
+	if c.lower != nil {
+		lower = pkValue(c.lower).String()
+	}
+	if c.upper != nil {
+		upper = pkValue(c.upper).String()
+	}
+	return fmt.Sprintf("(%s, %s]", lower, upper)
+}
+
+//where 生成chunk的查询条件
+func (c chunkInfo) where(pkCols []string) (string, []interface{}) {
+	return dbutil.RangeWhere(pkCols, c.lower, c.upper)
+}
+
 //GetChunkCount 获取chunk数
 func (t *TableInfo) GetChunkCount() (int, error) {
 	rowCount, err := t.GetRowCount()
@@ -42,7 +62,7 @@ func (t *TableInfo) CheckDBIsTidb() bool {
 
 
 //GetCrc32CheckSum 对数据使用CRC32计算
-func (t *TableInfo) GetCrc32CheckSum(where string) (string, error) {
+func (t *TableInfo) GetCrc32CheckSum(where string, args []interface{}) (string, error) {
 	/*
 		select * from t2;
 		+----+------+
@@ -51,13 +71,13 @@ func (t *TableInfo) GetCrc32CheckSum(where string) (string, error) {
 		|  1 | xxx  |
 		+----+------+
 
-		tidb> SELECT COALESCE(LOWER(CONV(BIT_XOR(CAST(CRC32(CONCAT_WS('#', id,name)) AS UNSIGNED)), 10, 16)), 0) AS checksum FROM `test`.`t2` where id >= 1 and id <= 200000;
+		tidb> SELECT COALESCE(LOWER(CONV(BIT_XOR(CAST(CRC32(CONCAT_WS('#', id,name, CONCAT(ISNULL(id),ISNULL(name)))) AS UNSIGNED)), 10, 16)), 0) AS checksum FROM `test`.`t2` where id >= 1 and id <= 200000;
 		+----------+
 		| checksum |
 		+----------+
 		| f2df7701 |
 		+----------+
-		SELECT COALESCE(LOWER(CONV(BIT_XOR(CAST(CRC32(CONCAT_WS('#', id,name)) AS UNSIGNED)), 10, 16)), 0) AS checksum FROM `test`.`t2` where id >= 1 and id <= 200000;
+		SELECT COALESCE(LOWER(CONV(BIT_XOR(CAST(CRC32(CONCAT_WS('#', id,name, CONCAT(ISNULL(id),ISNULL(name)))) AS UNSIGNED)), 10, 16)), 0) AS checksum FROM `test`.`t2` where id >= 1 and id <= 200000;
 		+----------+
 		| checksum |
 		+----------+
@@ -65,22 +85,17 @@ func (t *TableInfo) GetCrc32CheckSum(where string) (string, error) {
 		+----------+
 	*/
 
-	colsStr, err := dbutil.GetTableFieldStr(t.db, t.dbName, t.tableName, t.filter)
-	if err != nil {
-		return "", err
-	}
-
-	if t.where != "" && isAutoIncPk == false {
-		where = fmt.Sprintf("%s AND %s", t.where, where)
-	}
+	where = t.filterWhere(where)
 
 	var query string
-	query = fmt.Sprintf("SELECT COALESCE(LOWER(CONV(BIT_XOR(CAST(CRC32(CONCAT_WS('#',%s)) AS UNSIGNED)), 10, 16)), 0) AS checksum FROM `%s`.`%s` WHERE %s",
-						 colsStr, t.dbName, t.tableName, where)
+	query = fmt.Sprintf("SELECT COALESCE(LOWER(CONV(BIT_XOR(CAST(%s AS UNSIGNED)), 10, 16)), 0) AS checksum FROM `%s`.`%s` WHERE %s",
+						 dbutil.FormatRowCrc32(t.columnExprs()), t.dbName, t.tableName, where)
 	logs.Debug("CRC32 query: %v", query)
 
+	q, release := t.querier()
+	defer release()
 	var checksum sql.NullString
-	err = t.db.QueryRow(query).Scan(&checksum)
+	err := q.QueryRow(query, args...).Scan(&checksum)
 	if err != nil {
 		return "", err
 	}
@@ -92,34 +107,30 @@ func (t *TableInfo) GetCrc32CheckSum(where string) (string, error) {
 }
 
 //GetMd5CheckSum 对数据使用Md5计算
-func (t *TableInfo) GetMd5CheckSum(where string) (string, error) {
+func (t *TableInfo) GetMd5CheckSum(where string, args []interface{}) (string, error) {
 	/* 
-	SELECT COALESCE(LOWER(CONCAT(LPAD(CONV(BIT_XOR(CAST(CONV(SUBSTRING(@crc, 1, 16), 16, 10) AS UNSIGNED))
-	, 10, 16), 16, '0'), LPAD(CONV(BIT_XOR(CAST(CONV(SUBSTRING(@crc := md5(CONCAT_WS('#', id,CONVERT(title using utf8mb4),
-	created_date, CONCAT(ISNULL(id),ISNULL(title),ISNULL(created_date)))), 17, 16), 16, 10) AS UNSIGNED)), 
-	10, 16), 16, '0'))),0) AS checksum FROM `test`.`t2`;
+	SELECT COALESCE(LOWER(CONCAT(LPAD(CONV(BIT_XOR(CAST(CONV(SUBSTRING(md5(CONCAT_WS('#', id,CONVERT(title using utf8mb4),
+	created_date, CONCAT(ISNULL(id),ISNULL(title),ISNULL(created_date)))), 1, 16), 16, 10) AS UNSIGNED)), 10, 16), 16, '0'),
+	LPAD(CONV(BIT_XOR(CAST(CONV(SUBSTRING(md5(CONCAT_WS('#', id,CONVERT(title using utf8mb4),
+	created_date, CONCAT(ISNULL(id),ISNULL(title),ISNULL(created_date)))), 17, 16), 16, 10) AS UNSIGNED)), 10, 16), 16, '0'))),0) AS checksum FROM `test`.`t2`;
 	+----------------------------------+
 	| checksum                         |
 	+----------------------------------+
 	| 55c5c6144eb1f07b47da59d6901f6c33 |
 	+----------------------------------+
 	*/
-	colsStr, err := dbutil.GetTableFieldAndType(t.db, t.dbName, t.tableName, t.filter)
-	if err != nil {
-		return "", err
-	}
-	crc := dbutil.FormatCrc(colsStr)
+	crc := dbutil.FormatCrc(t.columnFields())
 
-	if t.where != "" && isAutoIncPk == false {
-		where = fmt.Sprintf("%s AND %s", t.where, where)
-	}
+	where = t.filterWhere(where)
 
 	var query string
 	query = fmt.Sprintf("SELECT %s FROM `%s`.`%s` WHERE %s", crc, t.dbName, t.tableName, where)
 	logs.Debug("Md5 query: %v", query)
 
+	q, release := t.querier()
+	defer release()
 	var checksum sql.NullString
-	err = t.db.QueryRow(query).Scan(&checksum)
+	err := q.QueryRow(query, args...).Scan(&checksum)
 	if err != nil {
 		return "", err
 	}
@@ -130,111 +141,183 @@ func (t *TableInfo) GetMd5CheckSum(where string) (string, error) {
 	return checksum.String, nil
 }
 
-//goDiffChunk 多线程执行任务
-func goDiffChunk(stbInfo, dtbInfo *TableInfo, chunkChan chan chunkInfo, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for chunk := range chunkChan {
-		DiffRowData(stbInfo, dtbInfo, chunk)
+//GetSha2CheckSum 对数据使用sha2计算
+func (t *TableInfo) GetSha2CheckSum(where string, args []interface{}) (string, error) {
+	where = t.filterWhere(where)
+
+	query := fmt.Sprintf("SELECT %s FROM `%s`.`%s` WHERE %s", dbutil.FormatSha2(t.columnFields()), t.dbName, t.tableName, where)
+	logs.Debug("Sha2 query: %v", query)
+
+	q, release := t.querier()
+	defer release()
+	var checksum sql.NullString
+	err := q.QueryRow(query, args...).Scan(&checksum)
+	if err != nil {
+		return "", err
 	}
-}
 
-// 非自增主键表分割chunk
-func splitTableToChunkForRandomPk(stb, dtb *TableInfo, start, end int) (*[]chunkInfo, error) {
-	var chunks []chunkInfo
-	offset := start
-	for offset < end {
-		sNextPost, err := dbutil.GetOffsetPk(stb.db, stb.dbName, stb.tableName, stb.pkName, offset, chunkSize)
-		if err != nil {
-			return nil, err
-		}
-		dNextPost, err := dbutil.GetOffsetPk(dtb.db, dtb.dbName, dtb.tableName, dtb.pkName, offset, chunkSize)
-		if err != nil {
-			return nil, err
-		}
+	if !checksum.Valid {
+		return "", nil
+	}
+	return checksum.String, nil
+}
 
-		nextPost := getMax(sNextPost, dNextPost)
-		n := newChunkInfo(offset, nextPost)
-		chunks = append(chunks, n)
-		offset = nextPost
+//GetMultisetCheckSum 没有可用的key时, 计算全表与行顺序无关的多重集合校验值
+func (t *TableInfo) GetMultisetCheckSum() (string, error) {
+	if len(t.shards) > 0 {
+		return t.shardMultisetCheckSum()
 	}
-	if offset > end {
-		chunks = append(chunks, newChunkInfo(end, offset))
+	where := t.where
+	if where == "" {
+		where = "true"
 	}
-	logs.Debug("chunks: %#v", chunks)
-	return &chunks, nil
+	query := fmt.Sprintf("SELECT %s FROM `%s`.`%s` WHERE %s", dbutil.FormatMultiset(t.columnFields()), t.dbName, t.tableName, where)
+	logs.Debug("multiset query: %v", query)
+
+	q, release := t.querier()
+	defer release()
+	var checksum sql.NullString
+	err := q.QueryRow(query).Scan(&checksum)
+	if err != nil {
+		return "", err
+	}
+	return checksum.String, nil
 }
 
-// 自增主键分割表
-func splitTableToChunkForAutoPk(stb, dtb *TableInfo, start, end int) (*[]chunkInfo, error) {
-	var chunks []chunkInfo
-	for offset := start; offset < end; offset += chunkSize {
-		n := newChunkInfo(offset, offset+chunkSize)
-		chunks = append(chunks, n)
+//compareCheckSum 并发计算两边chunk的checksum, 返回是否一致
+func compareCheckSum(stbInfo, dtbInfo *TableInfo, chunk chunkInfo, cs Checksummer) (bool, error) {
+	var dCheckSum string
+	var dErr error
+	done := make(chan struct{})
+	go func() {
+		dCheckSum, dErr = cs.Checksum(dtbInfo, chunk)
+		close(done)
+	}()
+	sCheckSum, sErr := cs.Checksum(stbInfo, chunk)
+	<-done
+
+	if sErr != nil {
+		return false, fmt.Errorf("sCheckSum err: %v", sErr)
+	}
+	if dErr != nil {
+		return false, fmt.Errorf("dCheckSum err: %v", dErr)
+	}
+	if sCheckSum != dCheckSum {
+		logs.Error("chunk %s sCheckSum: %s dCheckSum: %s", chunk, sCheckSum, dCheckSum)
+		return false, nil
 	}
-	logs.Debug("chunks: %#v", chunks)
-	return &chunks, nil
+	return true, nil
 }
 
-func splitTableToChunk(stb, dtb *TableInfo, start, end int) (chunks *[]chunkInfo, err error) {
-	if isAutoIncPk {
-		chunks, err = splitTableToChunkForAutoPk(stb, dtb, start, end)
-	} else {
-		chunks, err = splitTableToChunkForRandomPk(stb, dtb, start, end)
+//goCheckSumChunk 计算chunk的checksum, 不一致的chunk交给对比行数据的线程
+func goCheckSumChunk(stbInfo, dtbInfo *TableInfo, ts *tableState, cs Checksummer, chunkChan <-chan chunkInfo, diffChan chan<- chunkInfo, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for chunk := range chunkChan {
+		start := time.Now()
+		equal, err := compareCheckSum(stbInfo, dtbInfo, chunk, cs)
+		if err != nil {
+			// 不对比行数据, 表的状态为error, -resume时重新校验
+			logs.Error("chunk %s %v", chunk, err)
+			ckpt.SetChunk(ts, chunk.id, chunkError, nil, err)
+			continue
+		}
+		if tuner != nil && chunk.size > 0 {
+			tuner.observe(chunk.size, time.Since(start))
+		}
+		if equal {
+			ckpt.SetChunk(ts, chunk.id, chunkEqual, nil, nil)
+			continue
+		}
+		diffChan <- chunk
 	}
-	return
 }
 
-//DiffChunk 对比chunk
-func diffChunk(ctx context.Context, stbInfo, dtbInfo *TableInfo, min, max int) {
-	chunkList, err := splitTableToChunk(stbInfo, dtbInfo, min, max)
-	if err != nil {
-		logs.Error("chunkList err: %v", err)
+//goDiffChunk 对比checksum不一致的chunk的行数据
+func goDiffChunk(stbInfo, dtbInfo *TableInfo, ts *tableState, cs Checksummer, diffChan <-chan chunkInfo, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for chunk := range diffChan {
+		var diffs []*rowDiff
+		var err error
+		if bisectRows > 0 {
+			diffs, err = bisectChunk(stbInfo, dtbInfo, chunk, cs)
+		} else {
+			diffs, err = DiffRowData(stbInfo, dtbInfo, chunk)
+		}
+		if err != nil {
+			logs.Error("chunk %s DiffRowData err: %v", chunk, err)
+			ckpt.SetChunk(ts, chunk.id, chunkError, nil, err)
+			continue
+		}
+		status := chunkEqual
+		if len(diffs) > 0 {
+			status = chunkDiff
+		}
+		ckpt.SetChunk(ts, chunk.id, status, diffs, nil)
 	}
+}
 
+//DiffChunk 对比chunk, 已经完成的chunk从checkpoint中恢复不一致的行
+//没有分割完成时, 一边分割一边校验, 不需要等待全部chunk分割完成
+func diffChunk(ctx context.Context, stbInfo, dtbInfo *TableInfo, splitter chunkSplitter, threads int, cs Checksummer, ts *tableState) error {
 	chunkChan := make(chan chunkInfo, threads)
+	diffChan := make(chan chunkInfo, diffThreads)
 
-	wg := new(sync.WaitGroup)
+	// checksum和对比行数据分为两个阶段, 各自使用单独的线程数
+	checkWg := new(sync.WaitGroup)
 	for i := 0; i < threads; i++ {
-		wg.Add(1)
-		go goDiffChunk(stbInfo, dtbInfo, chunkChan, wg)
+		checkWg.Add(1)
+		go goCheckSumChunk(stbInfo, dtbInfo, ts, cs, chunkChan, diffChan, checkWg)
+	}
+	diffWg := new(sync.WaitGroup)
+	for i := 0; i < diffThreads; i++ {
+		diffWg.Add(1)
+		go goDiffChunk(stbInfo, dtbInfo, ts, cs, diffChan, diffWg)
 	}
 
-	for _, chunk := range *chunkList {
-		select {
-			case <-ctx.Done():
-				logs.Info("exit...")
-				return
-			default:
-		}
-
-		where := fmt.Sprintf("%s >= %d and %s <= %d", stbInfo.pkName, chunk.pkStart, stbInfo.pkName, chunk.pkEnd)
-
-		var sCheckSum, dCheckSum string
-		if stbInfo.CheckDBIsTidb() || dtbInfo.CheckDBIsTidb() {  // tidb使用crc 32
-			sCheckSum, err = stbInfo.GetCrc32CheckSum(where)
-			if err != nil {
-				logs.Error("sCheckSum err: %v", err)
+	var err error
+	if ts.Planned {
+		chunkList, status := ckpt.Plan(ts)
+		logs.Info("resume %d chunks from checkpoint", len(chunkList))
+		for _, chunk := range chunkList {
+			if status[chunk.id] == chunkEqual || status[chunk.id] == chunkDiff {
+				recordDiffs(ckpt.ChunkDiffs(ts, chunk.id))
+				continue
 			}
-			dCheckSum, err = dtbInfo.GetCrc32CheckSum(where)
-			if err != nil {
-				logs.Error("dCheckSum err: %v", err)
+
+			if ctx.Err() != nil {
+				logs.Info("exit...")
+				break
 			}
-		} else {
-			sCheckSum, err = stbInfo.GetMd5CheckSum(where)
-			if err != nil {
-				logs.Error("sCheckSum err: %v", err)
+			chunkChan <- chunk
+		}
+	} else {
+		logs.Info("%s.%s split chunks by %s", stbInfo.dbName, stbInfo.tableName, splitter.Name())
+		ckpt.ResetPlan(ts)
+		planned := make(chan chunkInfo, threads)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- splitter.Split(ctx, planned)
+			close(planned)
+		}()
+		for chunk := range planned {
+			if ctx.Err() != nil {
+				// 等待分割退出
+				continue
 			}
-			dCheckSum, err = dtbInfo.GetMd5CheckSum(where)
-			if err != nil {
-				logs.Error("dCheckSum err: %v", err)
+			chunk.id = ckpt.AddChunk(ts, chunk)
+			if tuner != nil && chunk.size > 0 {
+				tuner.record(chunk.size)
 			}
-		}
-
-		if sCheckSum != dCheckSum {
 			chunkChan <- chunk
-			logs.Error("sCheckSum: %s dCheckSum: %s", sCheckSum, dCheckSum)
+		}
+		if err = <-errc; err == nil {
+			ckpt.FinishPlan(ts)
 		}
 	}
 	close(chunkChan)
-	wg.Wait()
+	checkWg.Wait()
+	close(diffChan)
+	diffWg.Wait()
+	ckpt.Save(true)
+	return err
 }
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/forest11/checktable/config"
+	"github.com/forest11/checktable/dbutil"
+)
+
+//column 对比数据的字段, 两边按顺序一一对应
+type column struct {
+	name     string // 源表字段名, 报告和修复语句中使用
+	expr     string // 本表查询该字段的表达式
+	dataType string // 字段类型, 目标表为表达式时使用源表字段的类型
+}
+
+// 映射的值为普通字段名时对比表结构, 其他作为表达式
+var identifier = regexp.MustCompile("^`?\\w+`?$")
+
+//columnMapping 源表字段与目标表字段的对应关系, 字段名不区分大小写
+type columnMapping struct {
+	columns map[string]string // 源表字段 => 目标表字段或表达式
+	sIgnore map[string]bool
+	dIgnore map[string]bool
+}
+
+func newColumnMapping(c config.TableConfig) *columnMapping {
+	m := &columnMapping{
+		columns: make(map[string]string, len(c.Columns)),
+		sIgnore: make(map[string]bool, len(c.SourceIgnore)),
+		dIgnore: make(map[string]bool, len(c.DestIgnore)),
+	}
+	for k, v := range c.Columns {
+		m.columns[strings.ToLower(k)] = v
+	}
+	for _, c := range c.SourceIgnore {
+		m.sIgnore[strings.ToLower(c)] = true
+	}
+	for _, c := range c.DestIgnore {
+		m.dIgnore[strings.ToLower(c)] = true
+	}
+	return m
+}
+
+//empty 没有配置字段映射和忽略的字段
+func (m *columnMapping) empty() bool {
+	return len(m.columns) == 0 && len(m.sIgnore) == 0 && len(m.dIgnore) == 0
+}
+
+//destField 源表字段对应的目标表字段, 映射为表达式时返回表达式和false
+func (m *columnMapping) destField(src string) (string, bool) {
+	v, ok := m.columns[strings.ToLower(src)]
+	if !ok {
+		return src, true
+	}
+	if !identifier.MatchString(v) {
+		return v, false
+	}
+	return strings.Trim(v, "`"), true
+}
+
+//destExpr 目标表查询源表字段使用的表达式
+func (m *columnMapping) destExpr(src string) string {
+	f, ok := m.destField(src)
+	if !ok {
+		return fmt.Sprintf("(%s)", f)
+	}
+	return fmt.Sprintf("`%s`", f)
+}
+
+//compared 源表字段是否对比数据
+func (m *columnMapping) compared(src string) bool {
+	if m.sIgnore[strings.ToLower(src)] {
+		return false
+	}
+	f, ok := m.destField(src)
+	return !ok || !m.dIgnore[strings.ToLower(f)]
+}
+
+//destKey 目标表匹配行使用的key, key字段不能映射为表达式
+func (m *columnMapping) destKey(pkCols []string) ([]string, error) {
+	cols := make([]string, 0, len(pkCols))
+	for _, c := range pkCols {
+		f, ok := m.destField(c)
+		if !ok {
+			return nil, fmt.Errorf("key column %s is mapped to expression %s", c, f)
+		}
+		cols = append(cols, f)
+	}
+	return cols, nil
+}
+
+//fixColumns 修复语句写入的字段, 返回源表字段和对应的目标表字段, 跳过不对比和映射为表达式的字段
+func (m *columnMapping) fixColumns(cols []string) ([]string, []string) {
+	var src, dest []string
+	for _, c := range cols {
+		f, ok := m.destField(c)
+		if !ok || !m.compared(c) {
+			continue
+		}
+		src = append(src, c)
+		dest = append(dest, f)
+	}
+	return src, dest
+}
+
+//unfixable 修复语句无法写入的目标表字段: 映射为表达式的字段和目标表不对比的字段, 值不能从源表得到
+func (m *columnMapping) unfixable() []string {
+	var cols []string
+	for src := range m.columns {
+		if f, ok := m.destField(src); !ok && m.compared(src) {
+			cols = append(cols, fmt.Sprintf("%s:%s", src, f))
+		}
+	}
+	for c := range m.dIgnore {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+//mapSource 按目标表的字段名改写源表结构, 去掉不对比和映射为表达式的字段, 以及包含这些字段的索引
+func (m *columnMapping) mapSource(s *dbutil.TableStructure) *dbutil.TableStructure {
+	ms := &dbutil.TableStructure{}
+	for _, c := range s.Columns {
+		f, ok := m.destField(c.Name)
+		if !ok || !m.compared(c.Name) {
+			continue
+		}
+		c.Name = f
+		ms.Columns = append(ms.Columns, c)
+	}
+	for _, idx := range s.Indexes {
+		cols := make([]string, 0, len(idx.Columns))
+		for _, c := range idx.Columns {
+			// 前缀索引的字段为 col(10)
+			name, prefix := c, ""
+			if i := strings.Index(c, "("); i > 0 {
+				name, prefix = c[:i], c[i:]
+			}
+			if name == "(expression)" {
+				cols = append(cols, c)
+				continue
+			}
+			f, ok := m.destField(name)
+			if _, found := ms.Column(f); !ok || !found {
+				cols = nil
+				break
+			}
+			cols = append(cols, f+prefix)
+		}
+		if cols == nil {
+			continue
+		}
+		idx.Columns = cols
+		ms.Indexes = append(ms.Indexes, idx)
+	}
+	return ms
+}
+
+// 表达式中的单词, 用于找到表达式使用的目标表字段
+var exprWord = regexp.MustCompile(`\w+`)
+
+//inExpr 目标表字段是否在映射的表达式中使用
+func (m *columnMapping) inExpr(dest string) bool {
+	for src := range m.columns {
+		f, ok := m.destField(src)
+		if ok {
+			continue
+		}
+		for _, w := range exprWord.FindAllString(f, -1) {
+			if strings.EqualFold(w, dest) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//filterDest 去掉目标表不对比和表达式使用的字段, 以及包含这些字段的索引
+func (m *columnMapping) filterDest(d *dbutil.TableStructure) *dbutil.TableStructure {
+	md := &dbutil.TableStructure{}
+	for _, c := range d.Columns {
+		if !m.dIgnore[strings.ToLower(c.Name)] && !m.inExpr(c.Name) {
+			md.Columns = append(md.Columns, c)
+		}
+	}
+	for _, idx := range d.Indexes {
+		kept := true
+		for _, c := range idx.Columns {
+			if i := strings.Index(c, "("); i > 0 {
+				c = c[:i]
+			}
+			if _, ok := md.Column(c); !ok && c != "(expression)" {
+				kept = false
+			}
+		}
+		if kept {
+			md.Indexes = append(md.Indexes, idx)
+		}
+	}
+	return md
+}
+
+//setColumns 设置两边对比数据的字段, names为源表字段
+func setColumns(stb, dtb *TableInfo, names []string, s, d *dbutil.TableStructure) {
+	stb.columns, dtb.columns = nil, nil
+	for _, n := range names {
+		sc, _ := s.Column(n)
+		stb.columns = append(stb.columns, column{name: n, expr: fmt.Sprintf("`%s`", n), dataType: sc.DataType})
+
+		c := column{name: n, expr: dtb.mapping.destExpr(n), dataType: sc.DataType}
+		if f, ok := dtb.mapping.destField(n); ok {
+			if dc, ok := d.Column(f); ok {
+				c.dataType = dc.DataType
+			}
+		}
+		dtb.columns = append(dtb.columns, c)
+	}
+}
+
+//compareCols 对比数据的字段名
+func (t *TableInfo) compareCols() []string {
+	cols := make([]string, 0, len(t.columns))
+	for _, c := range t.columns {
+		cols = append(cols, c.name)
+	}
+	return cols
+}
+
+//columnExprs 对比数据的字段表达式
+func (t *TableInfo) columnExprs() []string {
+	exprs := make([]string, 0, len(t.columns))
+	for _, c := range t.columns {
+		exprs = append(exprs, c.expr)
+	}
+	return exprs
+}
+
+//columnFields 对比数据的字段表达式和类型, 格式为 表达式#类型
+func (t *TableInfo) columnFields() []string {
+	fields := make([]string, 0, len(t.columns))
+	for _, c := range t.columns {
+		fields = append(fields, c.expr+"#"+c.dataType)
+	}
+	return fields
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/forest11/checktable/config"
+	"github.com/forest11/checktable/dbutil"
+)
+
+func testMapping() *columnMapping {
+	return newColumnMapping(config.TableConfig{
+		Columns:      map[string]string{"Name": "full_name", "amount": "amount_cents/100", "code": "`sku`"},
+		SourceIgnore: []string{"updated_at"},
+		DestIgnore:   []string{"Audit"},
+	})
+}
+
+func TestColumnMappingDestField(t *testing.T) {
+	m := testMapping()
+	tests := []struct {
+		src      string
+		field    string
+		isColumn bool
+		expr     string
+		compared bool
+	}{
+		{"id", "id", true, "`id`", true},
+		{"name", "full_name", true, "`full_name`", true},
+		{"NAME", "full_name", true, "`full_name`", true},
+		{"code", "sku", true, "`sku`", true},
+		{"amount", "amount_cents/100", false, "(amount_cents/100)", true},
+		{"updated_at", "updated_at", true, "`updated_at`", false},
+		{"audit", "audit", true, "`audit`", false},
+	}
+	for _, tt := range tests {
+		f, ok := m.destField(tt.src)
+		if f != tt.field || ok != tt.isColumn {
+			t.Errorf("destField(%s) = %s, %v, want %s, %v", tt.src, f, ok, tt.field, tt.isColumn)
+		}
+		if got := m.destExpr(tt.src); got != tt.expr {
+			t.Errorf("destExpr(%s) = %s, want %s", tt.src, got, tt.expr)
+		}
+		if got := m.compared(tt.src); got != tt.compared {
+			t.Errorf("compared(%s) = %v, want %v", tt.src, got, tt.compared)
+		}
+	}
+}
+
+func TestColumnMappingKeyAndFix(t *testing.T) {
+	m := testMapping()
+	tests := []struct {
+		pkCols []string
+		want   []string
+		err    bool
+	}{
+		{[]string{"id"}, []string{"id"}, false},
+		{[]string{"id", "name"}, []string{"id", "full_name"}, false},
+		{[]string{"amount"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := m.destKey(tt.pkCols)
+		if (err != nil) != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("destKey(%v) = %v, %v, want %v, err %v", tt.pkCols, got, err, tt.want, tt.err)
+		}
+	}
+
+	src, dest := m.fixColumns([]string{"id", "name", "amount", "code", "updated_at", "audit"})
+	if want := []string{"id", "name", "code"}; !reflect.DeepEqual(src, want) {
+		t.Errorf("fixColumns source = %v, want %v", src, want)
+	}
+	if want := []string{"id", "full_name", "sku"}; !reflect.DeepEqual(dest, want) {
+		t.Errorf("fixColumns dest = %v, want %v", dest, want)
+	}
+	if got, want := m.unfixable(), []string{"amount:amount_cents/100", "audit"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unfixable = %v, want %v", got, want)
+	}
+	if got := newColumnMapping(config.TableConfig{}).unfixable(); len(got) != 0 {
+		t.Errorf("empty mapping unfixable = %v", got)
+	}
+}
+
+func TestColumnMappingSchema(t *testing.T) {
+	m := testMapping()
+	s := &dbutil.TableStructure{
+		Columns: []dbutil.ColumnInfo{{Name: "id"}, {Name: "name"}, {Name: "amount"}, {Name: "updated_at"}},
+		Indexes: []dbutil.IndexDef{
+			{Name: "PRIMARY", Columns: []string{"id"}},
+			{Name: "idx_name", Columns: []string{"name(10)"}},
+			{Name: "idx_amount", Columns: []string{"amount"}},
+			{Name: "idx_updated", Columns: []string{"id", "updated_at"}},
+		},
+	}
+	ms := m.mapSource(s)
+	var cols, idx []string
+	for _, c := range ms.Columns {
+		cols = append(cols, c.Name)
+	}
+	for _, i := range ms.Indexes {
+		idx = append(idx, indexString(i))
+	}
+	if want := []string{"id", "full_name"}; !reflect.DeepEqual(cols, want) {
+		t.Errorf("mapSource columns = %v, want %v", cols, want)
+	}
+	if want := []string{"PRIMARY(id)", "INDEX(full_name(10))"}; !reflect.DeepEqual(idx, want) {
+		t.Errorf("mapSource indexes = %v, want %v", idx, want)
+	}
+
+	d := &dbutil.TableStructure{
+		Columns: []dbutil.ColumnInfo{{Name: "id"}, {Name: "full_name"}, {Name: "amount_cents"}, {Name: "audit"}},
+		Indexes: []dbutil.IndexDef{
+			{Name: "PRIMARY", Columns: []string{"id"}},
+			{Name: "idx_cents", Columns: []string{"amount_cents"}},
+			{Name: "idx_audit", Columns: []string{"audit(4)"}},
+		},
+	}
+	md := m.filterDest(d)
+	cols, idx = nil, nil
+	for _, c := range md.Columns {
+		cols = append(cols, c.Name)
+	}
+	for _, i := range md.Indexes {
+		idx = append(idx, indexString(i))
+	}
+	if want := []string{"id", "full_name"}; !reflect.DeepEqual(cols, want) {
+		t.Errorf("filterDest columns = %v, want %v", cols, want)
+	}
+	if want := []string{"PRIMARY(id)"}; !reflect.DeepEqual(idx, want) {
+		t.Errorf("filterDest indexes = %v, want %v", idx, want)
+	}
+}
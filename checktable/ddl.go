@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/forest11/checktable/config"
+	"github.com/forest11/checktable/dbutil"
+)
+
+//ddlStmt 修复表结构的语句, note不为空时需要人工确认, disabled为true时注释掉语句
+type ddlStmt struct {
+	sql      string
+	note     string
+	disabled bool
+}
+
+//String 输出到ddl文件
+func (s ddlStmt) String() string {
+	var b strings.Builder
+	if s.note != "" {
+		b.WriteString("-- [check] " + s.note + "\n")
+	}
+	if s.disabled {
+		b.WriteString("-- ")
+	}
+	b.WriteString(s.sql + ";\n")
+	return b.String()
+}
+
+// mysql8的默认排序规则, tidb 7.4之前不支持
+var collation0900 = regexp.MustCompile(`utf8mb4_0900_\w+`)
+
+//adjustCollation tidb不支持utf8mb4_0900的排序规则时改为utf8mb4_general_ci
+func adjustCollation(sql string, isTidb bool) (string, string) {
+	if !isTidb || !collation0900.MatchString(sql) {
+		return sql, ""
+	}
+	return collation0900.ReplaceAllString(sql, "utf8mb4_general_ci"), "utf8mb4_0900 collation changed to utf8mb4_general_ci for tidb"
+}
+
+//columnDefinition 生成字段定义
+func columnDefinition(c dbutil.ColumnInfo) string {
+	def := fmt.Sprintf("`%s` %s", c.Name, c.ColumnType)
+	if c.Charset != "" {
+		def += fmt.Sprintf(" CHARACTER SET %s COLLATE %s", c.Charset, c.Collation)
+	}
+	if !c.Nullable {
+		def += " NOT NULL"
+	}
+	if c.Default.Valid {
+		def += " DEFAULT " + defaultLiteral(c)
+	}
+	extra := strings.TrimSpace(strings.Replace(c.Extra, "DEFAULT_GENERATED", "", -1))
+	lower := strings.ToLower(extra)
+	if strings.Contains(lower, "auto_increment") {
+		def += " AUTO_INCREMENT"
+	}
+	if i := strings.Index(lower, "on update "); i >= 0 {
+		def += " " + strings.ToUpper(extra[i:])
+	}
+	return def
+}
+
+//defaultLiteral 默认值, 表达式默认值加括号, 其他按字符串转义
+func defaultLiteral(c dbutil.ColumnInfo) string {
+	v := normalizeDefault(c.Default)
+	lower := strings.ToLower(v)
+	switch {
+	case strings.HasPrefix(lower, "current_timestamp"):
+		return strings.ToUpper(v)
+	case strings.Contains(c.Extra, "DEFAULT_GENERATED"):
+		return fmt.Sprintf("(%s)", v)
+	case c.DataType == "bit":
+		return v
+	default:
+		return dbutil.QuoteValue(v)
+	}
+}
+
+//indexDefinition 生成添加索引的语句
+func indexDefinition(idx dbutil.IndexDef) string {
+	cols := make([]string, 0, len(idx.Columns))
+	for _, c := range idx.Columns {
+		if i := strings.Index(c, "("); i > 0 {
+			cols = append(cols, fmt.Sprintf("`%s`%s", c[:i], c[i:]))
+			continue
+		}
+		cols = append(cols, fmt.Sprintf("`%s`", c))
+	}
+	switch {
+	case idx.Name == "PRIMARY":
+		return fmt.Sprintf("ADD PRIMARY KEY (%s)", strings.Join(cols, ","))
+	case idx.Type == "FULLTEXT" || idx.Type == "SPATIAL":
+		return fmt.Sprintf("ADD %s INDEX `%s` (%s)", idx.Type, idx.Name, strings.Join(cols, ","))
+	case idx.Unique:
+		return fmt.Sprintf("ADD UNIQUE INDEX `%s` (%s)", idx.Name, strings.Join(cols, ","))
+	default:
+		return fmt.Sprintf("ADD INDEX `%s` (%s)", idx.Name, strings.Join(cols, ","))
+	}
+}
+
+//dropIndex 生成删除索引的语句
+func dropIndex(idx dbutil.IndexDef) string {
+	if idx.Name == "PRIMARY" {
+		return "DROP PRIMARY KEY"
+	}
+	return fmt.Sprintf("DROP INDEX `%s`", idx.Name)
+}
+
+//schemaDDL 生成使目标表与源表一致的语句, tidb不支持一条ALTER修改多项, 每项修改一条语句
+func schemaDDL(dbName, tableName string, s, d *dbutil.TableStructure, isTidb bool) []ddlStmt {
+	alter := fmt.Sprintf("ALTER TABLE `%s`.`%s` ", dbName, tableName)
+	var drops, adds, modifies, addIndexes, dropCols []ddlStmt
+
+	newIndex := func(idx dbutil.IndexDef) ddlStmt {
+		stmt := ddlStmt{sql: alter + indexDefinition(idx)}
+		for _, c := range idx.Columns {
+			if c == "(expression)" {
+				stmt.note, stmt.disabled = "expression index, write the expression manually", true
+			}
+		}
+		switch {
+		case isTidb && (idx.Type == "FULLTEXT" || idx.Type == "SPATIAL"):
+			stmt.note, stmt.disabled = fmt.Sprintf("tidb does not support %s index", idx.Type), true
+		case isTidb && idx.Name == "PRIMARY":
+			stmt.note = "tidb can not add primary key to a clustered index table"
+		}
+		return stmt
+	}
+	for _, si := range s.Indexes {
+		di, ok := d.Index(si.Name)
+		if ok && indexString(si) == indexString(di) {
+			continue
+		}
+		if ok {
+			stmt := ddlStmt{sql: alter + dropIndex(di)}
+			if isTidb && di.Name == "PRIMARY" {
+				stmt.note = "tidb can not drop primary key of a clustered index table"
+			}
+			drops = append(drops, stmt)
+		}
+		addIndexes = append(addIndexes, newIndex(si))
+	}
+	for _, di := range d.Indexes {
+		if _, ok := s.Index(di.Name); !ok {
+			stmt := ddlStmt{sql: alter + dropIndex(di)}
+			if isTidb && di.Name == "PRIMARY" {
+				stmt.note = "tidb can not drop primary key of a clustered index table"
+			}
+			drops = append(drops, stmt)
+		}
+	}
+
+	prev := ""
+	for _, sc := range s.Columns {
+		position := " FIRST"
+		if prev != "" {
+			position = fmt.Sprintf(" AFTER `%s`", prev)
+		}
+		prev = sc.Name
+
+		def := columnDefinition(sc)
+		var stmt ddlStmt
+		dc, ok := d.Column(sc.Name)
+		if !ok {
+			stmt = ddlStmt{sql: alter + "ADD COLUMN " + def + position}
+		} else if columnChanged(sc, dc) {
+			stmt = ddlStmt{sql: alter + "MODIFY COLUMN " + def}
+			if isTidb && sc.DataType != dc.DataType {
+				stmt.note = fmt.Sprintf("tidb may reject or rewrite the table when changing %s to %s, check the tidb version", dc.ColumnType, sc.ColumnType)
+			}
+			if isTidb && strings.Contains(def, " AUTO_INCREMENT") && !strings.Contains(columnDefinition(dc), " AUTO_INCREMENT") {
+				stmt.sql = strings.Replace(stmt.sql, " AUTO_INCREMENT", "", 1)
+				stmt.note = "tidb can not add AUTO_INCREMENT to an existing column, removed"
+			}
+		} else {
+			continue
+		}
+		if strings.Contains(strings.ToUpper(sc.Extra), "GENERATED") && !strings.Contains(sc.Extra, "DEFAULT_GENERATED") {
+			stmt.note, stmt.disabled = "generated column, write the expression manually", true
+		}
+		var note string
+		stmt.sql, note = adjustCollation(stmt.sql, isTidb)
+		if stmt.note == "" {
+			stmt.note = note
+		}
+		if ok {
+			modifies = append(modifies, stmt)
+		} else {
+			adds = append(adds, stmt)
+		}
+	}
+	for _, dc := range d.Columns {
+		if _, ok := s.Column(dc.Name); !ok {
+			dropCols = append(dropCols, ddlStmt{sql: fmt.Sprintf("%sDROP COLUMN `%s`", alter, dc.Name), note: "drops the column data"})
+		}
+	}
+
+	// 先删除索引, 再修改字段和添加索引, 最后删除字段
+	stmts := append(drops, adds...)
+	stmts = append(stmts, modifies...)
+	stmts = append(stmts, addIndexes...)
+	return append(stmts, dropCols...)
+}
+
+// 建表语句中的表名
+var createTableName = regexp.MustCompile("^CREATE TABLE `[^`]+`")
+
+//createTableDDL 目标表不存在时, 按源表的建表语句生成
+func createTableDDL(dbName, tableName, createSQL string, isTidb bool) []ddlStmt {
+	sql := createTableName.ReplaceAllString(createSQL, fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s`.`%s`", dbName, tableName))
+	stmt := ddlStmt{}
+	stmt.sql, stmt.note = adjustCollation(sql, isTidb)
+	if isTidb && (strings.Contains(sql, "FULLTEXT KEY") || strings.Contains(sql, "SPATIAL KEY")) {
+		stmt.note = "tidb does not support FULLTEXT/SPATIAL index, remove it before running"
+	}
+	return []ddlStmt{stmt}
+}
+
+//writeDDL 写入ddl文件, 每张表前写入注释
+func writeDDL(sTable, dTable string, stmts []ddlStmt) error {
+	if config.AppConf.DDLFile == "" || len(stmts) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("-- %s.%s => %s.%s\n", config.AppConf.SourceDB.DBName, sTable, config.AppConf.DestDB.DBName, dTable))
+	for _, s := range stmts {
+		b.WriteString(s.String())
+	}
+	b.WriteString("\n")
+	return writeFile(config.AppConf.DDLFile, b.String())
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/forest11/checktable/dbutil"
+)
+
+func TestSchemaDDL(t *testing.T) {
+	id := dbutil.ColumnInfo{Name: "id", DataType: "bigint", ColumnType: "bigint", Extra: "auto_increment"}
+	name := dbutil.ColumnInfo{Name: "name", DataType: "varchar", ColumnType: "varchar(20)", Nullable: true, Charset: "utf8mb4", Collation: "utf8mb4_general_ci"}
+	pk := dbutil.IndexDef{Name: "PRIMARY", Unique: true, Type: "BTREE", Columns: []string{"id"}}
+	idxName := dbutil.IndexDef{Name: "idx_name", Type: "BTREE", Columns: []string{"name(10)"}}
+
+	with := func(c dbutil.ColumnInfo, f func(*dbutil.ColumnInfo)) dbutil.ColumnInfo {
+		f(&c)
+		return c
+	}
+	alter := "ALTER TABLE `db`.`t` "
+	tests := []struct {
+		name   string
+		s, d   *dbutil.TableStructure
+		isTidb bool
+		want   []string
+	}{
+		{
+			name: "same",
+			s:    &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id, name}, Indexes: []dbutil.IndexDef{pk}},
+			d:    &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id, name}, Indexes: []dbutil.IndexDef{pk}},
+		},
+		{
+			name: "missing column",
+			s:    &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id, name}},
+			d:    &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id}},
+			want: []string{alter + "ADD COLUMN `name` varchar(20) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci AFTER `id`;\n"},
+		},
+		{
+			name: "missing first column with default",
+			s: &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{
+				{Name: "v", DataType: "int", ColumnType: "int", Default: sql.NullString{String: "0", Valid: true}}, id}},
+			d:    &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id}},
+			want: []string{alter + "ADD COLUMN `v` int NOT NULL DEFAULT '0' FIRST;\n"},
+		},
+		{
+			name: "modify and drop column",
+			s:    &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id, name}},
+			d: &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id, with(name, func(c *dbutil.ColumnInfo) { c.ColumnType = "varchar(10)" }),
+				{Name: "audit", DataType: "int", ColumnType: "int"}}},
+			want: []string{
+				alter + "MODIFY COLUMN `name` varchar(20) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci;\n",
+				"-- [check] drops the column data\n" + alter + "DROP COLUMN `audit`;\n",
+			},
+		},
+		{
+			name: "index changed, dropped before added",
+			s:    &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id, name}, Indexes: []dbutil.IndexDef{pk, idxName}},
+			d: &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id, name}, Indexes: []dbutil.IndexDef{pk,
+				{Name: "idx_name", Type: "BTREE", Columns: []string{"name"}}, {Name: "idx_old", Type: "BTREE", Columns: []string{"id"}}}},
+			want: []string{
+				alter + "DROP INDEX `idx_name`;\n",
+				alter + "DROP INDEX `idx_old`;\n",
+				alter + "ADD INDEX `idx_name` (`name`(10));\n",
+			},
+		},
+		{
+			name:   "tidb fulltext and expression index",
+			s:      &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id}, Indexes: []dbutil.IndexDef{{Name: "ft", Type: "FULLTEXT", Columns: []string{"id"}}, {Name: "ex", Type: "BTREE", Columns: []string{"(expression)"}}}},
+			d:      &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id}},
+			isTidb: true,
+			want: []string{
+				"-- [check] tidb does not support FULLTEXT index\n-- " + alter + "ADD FULLTEXT INDEX `ft` (`id`);\n",
+				"-- [check] expression index, write the expression manually\n-- " + alter + "ADD INDEX `ex` (`(expression)`);\n",
+			},
+		},
+		{
+			name:   "tidb collation and auto_increment",
+			s:      &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id, with(name, func(c *dbutil.ColumnInfo) { c.Collation = "utf8mb4_0900_ai_ci" })}},
+			d:      &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{with(id, func(c *dbutil.ColumnInfo) { c.Extra = "" }), name}},
+			isTidb: true,
+			want: []string{
+				"-- [check] tidb can not add AUTO_INCREMENT to an existing column, removed\n" + alter + "MODIFY COLUMN `id` bigint NOT NULL;\n",
+				"-- [check] utf8mb4_0900 collation changed to utf8mb4_general_ci for tidb\n" + alter + "MODIFY COLUMN `name` varchar(20) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci;\n",
+			},
+		},
+		{
+			name: "generated column",
+			s: &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id,
+				{Name: "g", DataType: "int", ColumnType: "int", Nullable: true, Extra: "VIRTUAL GENERATED"}}},
+			d:    &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{id}},
+			want: []string{"-- [check] generated column, write the expression manually\n-- " + alter + "ADD COLUMN `g` int AFTER `id`;\n"},
+		},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, s := range schemaDDL("db", "t", tt.s, tt.d, tt.isTidb) {
+			got = append(got, s.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\ngot:\n%s\nwant:\n%s", tt.name, strings.Join(got, ""), strings.Join(tt.want, ""))
+		}
+	}
+}
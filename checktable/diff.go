@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 
 	"github.com/forest11/checktable/dbutil"
 )
@@ -10,10 +11,17 @@ import (
 type TableInfo struct {
 	dbName    string
 	tableName string
-	pkName    string
+	pkCols    []string
+	autoPk    bool // 按整型主键的值分割chunk
 	filter    string
 	where     string
 	db        *sql.DB
+	snap      *dbutil.SnapshotPool // 快照连接池, 为nil时不使用快照
+	columns   []column             // 对比数据的字段
+	mapping   *columnMapping       // 字段映射, 两边使用同一个
+	canonical bool                 // 对比规范化后的值
+	shards    []shardTable         // 分片合并时的所有源分片
+	label     string               // 分片的标识, 不是分片时为空
 }
 
 //NewTableInfo 创建对象
@@ -26,20 +34,25 @@ func NewTableInfo(dbName, tableName, filter, where string) *TableInfo {
 	}
 }
 
-//DiffTableSchema 对比表字段是否一致
-func DiffTableSchema(stbInfo, dtbInfo *TableInfo) (bool, error) {
-	sCols, err := dbutil.GetTableSchema(stbInfo.db, stbInfo.dbName, stbInfo.tableName, stbInfo.filter)
-	if err != nil {
-		return false, err
+//filterWhere 在条件前加上表的where条件, chunk、行数和行数据的查询都要限制在where内
+func (t *TableInfo) filterWhere(where string) string {
+	if t.where == "" {
+		return where
 	}
+	return fmt.Sprintf("(%s) and %s", t.where, where)
+}
 
-	dCols, err := dbutil.GetTableSchema(dtbInfo.db, dtbInfo.dbName, dtbInfo.tableName, dtbInfo.filter)
-	if err != nil {
-		return false, err
-	}
+//chunkWhere chunk的查询条件, 包括表的where条件
+func (t *TableInfo) chunkWhere(chunk chunkInfo) (string, []interface{}) {
+	where, args := chunk.where(t.pkCols)
+	return t.filterWhere(where), args
+}
 
-	if sCols == dCols {
-		return true, nil
+//querier 获取查询数据使用的连接, 开启快照时使用快照连接, 查询结束后调用release归还
+func (t *TableInfo) querier() (dbutil.Querier, func()) {
+	if t.snap == nil {
+		return t.db, func() {}
 	}
-	return false, nil
+	c := t.snap.Get()
+	return c, func() { t.snap.Put(c) }
 }
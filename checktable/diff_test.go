@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChunkWhere(t *testing.T) {
+	tests := []struct {
+		name   string
+		where  string
+		autoPk bool
+		chunk  chunkInfo
+		want   string
+		args   []interface{}
+	}{
+		{"no where", "", true, newChunkInfo([]interface{}{1}, []interface{}{9}), "`id` > ? AND `id` <= ?", []interface{}{1, 9}},
+		{"auto pk first chunk", "tenant_id = 1", true, newChunkInfo(nil, []interface{}{9}), "(tenant_id = 1) and `id` <= ?", []interface{}{9}},
+		{"auto pk last chunk", "tenant_id = 1", true, newChunkInfo([]interface{}{9}, nil), "(tenant_id = 1) and `id` > ?", []interface{}{9}},
+		{"auto pk unbounded chunk", "tenant_id = 1 or tenant_id = 2", true, newChunkInfo(nil, nil), "(tenant_id = 1 or tenant_id = 2) and true", nil},
+		{"not auto pk", "tenant_id = 1", false, newChunkInfo([]interface{}{1}, []interface{}{9}), "(tenant_id = 1) and `id` > ? AND `id` <= ?", []interface{}{1, 9}},
+	}
+	for _, tt := range tests {
+		tb := NewTableInfo("test", "t1", "", tt.where)
+		tb.pkCols, tb.autoPk = []string{"id"}, tt.autoPk
+		where, args := tb.chunkWhere(tt.chunk)
+		if where != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, where, tt.want)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: args got %v, want %v", tt.name, args, tt.args)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/forest11/checktable/dbutil"
+)
+
+//pkValue 一行数据的主键值, 复合主键按字段顺序保存
+type pkValue []interface{}
+
+//String 编码主键, 用作map的key
+func (p pkValue) String() string {
+	/* (1, "a,b") => "1","a,b" */
+	vals := make([]string, 0, len(p))
+	for _, v := range p {
+		vals = append(vals, strconv.Quote(fmt.Sprintf("%v", v)))
+	}
+	return strings.Join(vals, ",")
+}
+
+//MarshalJSON 保存到checkpoint, 每个值带上类型前缀, 保证恢复后类型和精度不变
+func (p pkValue) MarshalJSON() ([]byte, error) {
+	/* (1, "abc", 2020-01-01) => ["i:1","s:YWJj","t:2020-01-01T00:00:00+08:00"] */
+	if p == nil {
+		return []byte("null"), nil
+	}
+	vals := make([]string, 0, len(p))
+	for _, v := range p {
+		switch x := v.(type) {
+		case nil:
+			vals = append(vals, "n:")
+		case int64:
+			vals = append(vals, "i:"+strconv.FormatInt(x, 10))
+		case int:
+			vals = append(vals, "i:"+strconv.Itoa(x))
+		case uint64:
+			vals = append(vals, "u:"+strconv.FormatUint(x, 10))
+		case float64:
+			vals = append(vals, "f:"+strconv.FormatFloat(x, 'g', -1, 64))
+		case float32:
+			vals = append(vals, "f:"+strconv.FormatFloat(float64(x), 'g', -1, 32))
+		case time.Time:
+			vals = append(vals, "t:"+x.Format(time.RFC3339Nano))
+		case string:
+			vals = append(vals, "s:"+base64.StdEncoding.EncodeToString([]byte(x)))
+		default:
+			return nil, fmt.Errorf("unsupported key value %T", v)
+		}
+	}
+	return json.Marshal(vals)
+}
+
+//UnmarshalJSON 从checkpoint恢复
+func (p *pkValue) UnmarshalJSON(data []byte) error {
+	var vals []string
+	if err := json.Unmarshal(data, &vals); err != nil {
+		return err
+	}
+	if vals == nil {
+		*p = nil
+		return nil
+	}
+
+	key := make(pkValue, 0, len(vals))
+	for _, v := range vals {
+		if len(v) < 2 || v[1] != ':' {
+			return fmt.Errorf("invalid key value %s", v)
+		}
+		var val interface{}
+		var err error
+		switch v[0] {
+		case 'n':
+		case 'i':
+			val, err = strconv.ParseInt(v[2:], 10, 64)
+		case 'u':
+			val, err = strconv.ParseUint(v[2:], 10, 64)
+		case 'f':
+			val, err = strconv.ParseFloat(v[2:], 64)
+		case 't':
+			var t time.Time
+			t, err = time.Parse(time.RFC3339Nano, v[2:])
+			val = t.In(time.Local)
+		case 's':
+			var b []byte
+			b, err = base64.StdEncoding.DecodeString(v[2:])
+			val = string(b)
+		default:
+			err = fmt.Errorf("invalid key value %s", v)
+		}
+		if err != nil {
+			return err
+		}
+		key = append(key, val)
+	}
+	*p = key
+	return nil
+}
+
+//pkValues 转换为[][]interface{}
+func pkValues(list []pkValue) [][]interface{} {
+	keys := make([][]interface{}, 0, len(list))
+	for _, k := range list {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+const (
+	keyPrimary   = "primary"
+	keyUnique    = "unique"
+	keyTidbRowid = "tidb_rowid"
+	keyMultiset  = "multiset"
+
+	tidbRowidCol = "_tidb_rowid"
+)
+
+//chooseKey 选择分割chunk和匹配行数据的key: 主键 > NOT NULL唯一索引 > _tidb_rowid(两边都是tidb) > 全表多重集合校验
+func chooseKey(stb, dtb *TableInfo) (string, []string, error) {
+	pkCols, err := dbutil.GetPKColumns(stb.db, stb.dbName, stb.tableName)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(pkCols) > 0 {
+		return keyPrimary, pkCols, nil
+	}
+
+	uks, err := dbutil.GetUniqueKeys(stb.db, stb.dbName, stb.tableName)
+	if err != nil {
+		return "", nil, err
+	}
+	var best *dbutil.IndexInfo
+	for i := range uks {
+		uk := &uks[i]
+		if !uk.NotNull || uk.Partial {
+			continue
+		}
+		if best == nil || len(uk.Columns) < len(best.Columns) {
+			best = uk
+		}
+	}
+	if best != nil {
+		return fmt.Sprintf("%s(%s)", keyUnique, best.Name), best.Columns, nil
+	}
+
+	if stb.CheckDBIsTidb() && dtb.CheckDBIsTidb() {
+		return keyTidbRowid, []string{tidbRowidCol}, nil
+	}
+	return keyMultiset, nil, nil
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 
@@ -18,13 +21,16 @@ import (
 )
 
 type pkList struct {
-	pk []string
-	rw sync.RWMutex
+	pk   []pkValue
+	cols map[string][]string // 主键 => 不一致的字段, 只有updateList使用
+	rw   sync.RWMutex
 }
 
 var (
 	chunkSize   int
 	threads     int
+	diffThreads int
+	bisectRows  int
 	isAutoIncPk bool
 	insertList  pkList
 	updateList  pkList
@@ -34,12 +40,14 @@ var (
 // NewpKList 初始化对象
 func NewpKList() pkList {
 	return pkList{
-		pk: make([]string, 0, 1000),
+		pk:   make([]pkValue, 0, 1000),
+		cols: make(map[string][]string),
 	}
 }
 
 func main() {
 	var confFile = flag.String("f", "checksum.conf", "checktable conf")
+	var resume = flag.Bool("resume", false, "resume unfinished chunks from checkpoint")
 	flag.Parse()
 	err := config.InitConfig(*confFile)
 	if err != nil {
@@ -48,6 +56,8 @@ func main() {
 
 	chunkSize = config.AppConf.ChunkSize
 	threads = config.AppConf.ThreadsNum
+	diffThreads = config.AppConf.DiffThreadsNum
+	bisectRows = config.AppConf.BisectRows
 	isAutoIncPk = config.AppConf.PkAutoInc
 
 	err = log.InitLog(config.AppConf.LogPath, config.AppConf.Level)
@@ -56,6 +66,11 @@ func main() {
 	}
 	logs.Info("app config:%#v", config.AppConf)
 
+	ckpt, err = newCheckpoint(config.AppConf.CheckpointFile, config.AppConf.CheckpointInterval, *resume)
+	if err != nil {
+		panic(fmt.Sprintf("init checkpoint failed, err:%v", err))
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		// 捕获退出信息
@@ -69,44 +84,294 @@ func main() {
 }
 
 func run(ctx context.Context) {
-	insertList = NewpKList()
-	updateList = NewpKList()
-	deleteList = NewpKList()
-	sTB := NewTableInfo(config.AppConf.SourceDB.DBName, config.AppConf.SourceDB.TableName, config.AppConf.FilterFiled, config.AppConf.WhereFiled)
-	dTB := NewTableInfo(config.AppConf.DestDB.DBName, config.AppConf.DestDB.TableName, config.AppConf.FilterFiled, config.AppConf.WhereFiled)
+	var sConn *sql.DB
+	var err error
+	if config.AppConf.Merge() {
+		// 分片合并模式, 源表结构从第一个分片读取, 不使用快照
+		shards, closeShards, err := initShards()
+		if err != nil {
+			panic(err)
+		}
+		defer closeShards()
+		mergeShards = shards
+		sConn = shards[0].db
+		logs.Info("merge shards: %d", len(shards))
+	} else {
+		sConn, err = dbutil.InitDB(config.AppConf.SourceDB.Addr, config.AppConf.SourceDB.Port, config.AppConf.SourceDB.User, config.AppConf.SourceDB.Pwd, config.AppConf.SourceDB.DBName)
+		if err != nil {
+			panic(err)
+		}
+		defer sConn.Close()
+	}
 
-	sConn, err := dbutil.InitDB(config.AppConf.SourceDB.Addr, config.AppConf.SourceDB.Port, config.AppConf.SourceDB.User, config.AppConf.SourceDB.Pwd, config.AppConf.SourceDB.DBName)
+	dConn, err := dbutil.InitDB(config.AppConf.DestDB.Addr, config.AppConf.DestDB.Port, config.AppConf.DestDB.User, config.AppConf.DestDB.Pwd, config.AppConf.DestDB.DBName)
 	if err != nil {
 		panic(err)
 	}
-	sTB.db = sConn
-	defer sTB.db.Close()
+	defer dConn.Close()
 
-	dConn, err := dbutil.InitDB(config.AppConf.DestDB.Addr, config.AppConf.DestDB.Port, config.AppConf.DestDB.User, config.AppConf.DestDB.Pwd, config.AppConf.DestDB.DBName)
+	var sSnap *dbutil.SnapshotPool
+	if !config.AppConf.Merge() {
+		sSnap, err = initSnapshot(ctx, sConn, config.AppConf.SourceDB)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if sSnap != nil {
+		defer sSnap.Close()
+	}
+
+	dSnap, err := initSnapshot(ctx, dConn, config.AppConf.DestDB)
 	if err != nil {
 		panic(err)
 	}
-	dTB.db = dConn
-	defer dTB.db.Close()
+	if dSnap != nil {
+		defer dSnap.Close()
+	}
 
-	schemaIsOk, err := DiffTableSchema(sTB, dTB)
-	if err != nil || !schemaIsOk {
-		panic(fmt.Errorf("%s.%s filed is diff, err: %s", sTB.dbName, sTB.tableName, err))
+	pairs, err := getTablePairs(sConn)
+	if err != nil {
+		panic(err)
 	}
+	logs.Info("check tables: %d", len(pairs))
 
-	pk, err := dbutil.GetPKName(sTB.db, sTB.dbName, sTB.tableName)
+	dTables, err := dbutil.GetTables(dConn, config.AppConf.DestDB.DBName)
 	if err != nil {
 		panic(err)
 	}
-	sTB.pkName = pk
-	dTB.pkName = pk
+	dTableSet := NewSet(dTables...)
+
+	runStart := time.Now()
+	summary := newCheckSummary()
+	for _, p := range pairs {
+		r := &tableResult{sTable: p.sTable, dTable: p.dTable}
+		select {
+		case <-ctx.Done():
+			r.status = statusSkipped
+			summary.Add(r)
+			continue
+		default:
+		}
+
+		if ts := ckpt.FinishedTable(p); ts != nil {
+			logs.Info("%s => %s is finished in checkpoint", p.sTable, p.dTable)
+			r.strategy, r.checksum, r.status = ts.Strategy, ts.Checksum, ts.Status
+			r.insert, r.update, r.delete, r.resolved = ts.Insert, ts.Update, ts.Delete, ts.Resolved
+			if ts.Error != "" {
+				r.err = errors.New(ts.Error)
+			}
+			r.rows = ckpt.TableDiffs(ts)
+			r.duplicate = countDiffs(r.rows, diffDuplicateKey)
+			r.schema = ts.Schema
+			r.chunkSizes = ts.ChunkSizes
+			summary.Add(r)
+			continue
+		}
+
+		if !dTableSet.Has(p.dTable) {
+			r.status = statusError
+			r.err = fmt.Errorf("destination table %s.%s not exists", config.AppConf.DestDB.DBName, p.dTable)
+			if err = writeCreateTable(sConn, dConn, p); err != nil {
+				logs.Error("%s write create table err:%v", p.sTable, err)
+			}
+			summary.Add(r)
+			continue
+		}
+
+		start := time.Now()
+		checkTable(ctx, sConn, dConn, sSnap, dSnap, p, r)
+		r.cost = time.Since(start)
+		summary.Add(r)
+	}
+	summary.Print()
+	if err = summary.writeReport(runStart); err != nil {
+		logs.Error("write report err:%v", err)
+	}
+}
+
+//writeCreateTable 目标表不存在时, 按源表(分片合并时为第一个分片)的建表语句写入ddl文件
+func writeCreateTable(sConn, dConn *sql.DB, p tablePair) error {
+	if config.AppConf.DDLFile == "" {
+		return nil
+	}
+	db, dbName, tableName := sConn, config.AppConf.SourceDB.DBName, p.sTable
+	if len(mergeShards) > 0 {
+		// 分片合并时没有源库, 按第一个分片的建表语句
+		db, dbName, tableName = mergeShards[0].db, mergeShards[0].dbName, mergeShards[0].tableName
+	}
+	createSQL, err := dbutil.GetCreateTableSQL(db, dbName, tableName)
+	if err != nil {
+		return err
+	}
+	isTidb, _ := dbutil.IsTiDB(dConn)
+	return writeDDL(p.sTable, p.dTable, createTableDDL(config.AppConf.DestDB.DBName, p.dTable, createSQL, isTidb))
+}
+
+//initSnapshot 按配置创建快照连接池, 没有配置snapshot时返回nil
+func initSnapshot(ctx context.Context, db *sql.DB, info config.DBInfo) (*dbutil.SnapshotPool, error) {
+	switch info.Snapshot {
+	case "":
+		return nil, nil
+	case "consistent":
+		logs.Info("%s:%s use consistent snapshot", info.Addr, info.Port)
+		return dbutil.NewMySQLSnapshotPool(ctx, db, config.AppConf.SnapshotPoolSize, config.AppConf.SnapshotLock)
+	default:
+		logs.Info("%s:%s use tidb_snapshot %s", info.Addr, info.Port, info.Snapshot)
+		return dbutil.NewTiDBSnapshotPool(ctx, db, config.AppConf.SnapshotPoolSize, info.Snapshot)
+	}
+}
 
-	sChunkCount, err := sTB.GetChunkCount()
+//checkTable 校验单表, 结果写入r
+func checkTable(ctx context.Context, sConn, dConn *sql.DB, sSnap, dSnap *dbutil.SnapshotPool, p tablePair, r *tableResult) {
+	logs.Info("start check %s.%s => %s.%s", config.AppConf.SourceDB.DBName, p.sTable, config.AppConf.DestDB.DBName, p.dTable)
+	resetDiffs()
+	tc := config.GetTableConfig(p.sTable)
+	sTB := NewTableInfo(config.AppConf.SourceDB.DBName, p.sTable, config.AppConf.FilterFiled, tc.SourceWhere)
+	dTB := NewTableInfo(config.AppConf.DestDB.DBName, p.dTable, config.AppConf.FilterFiled, tc.DestWhere)
+	sTB.db, sTB.snap = sConn, sSnap
+	dTB.db, dTB.snap = dConn, dSnap
+	sTB.mapping = newColumnMapping(tc)
+	dTB.mapping = sTB.mapping
+	if len(mergeShards) > 0 {
+		sTB.setShards(mergeShards)
+	}
+
+	sStruct, err := dbutil.GetTableStructure(sTB.db, sTB.dbName, sTB.tableName)
+	if err != nil {
+		r.status = statusError
+		r.err = fmt.Errorf("%s.%s get schema err: %v", sTB.dbName, sTB.tableName, err)
+		return
+	}
+	dStruct, err := dbutil.GetTableStructure(dTB.db, dTB.dbName, dTB.tableName)
+	if err != nil {
+		r.status = statusError
+		r.err = fmt.Errorf("%s.%s get schema err: %v", dTB.dbName, dTB.tableName, err)
+		return
+	}
+	schemaDiffs, cols := DiffTableSchema(sTB, sStruct, dStruct)
+	if len(sTB.shards) > 0 {
+		shardDiffs, err := diffShardSchema(sTB, sStruct)
+		if err != nil {
+			r.status = statusError
+			r.err = err
+			return
+		}
+		schemaDiffs = append(schemaDiffs, shardDiffs...)
+	}
+	r.schema = schemaDiffs
+	if len(schemaDiffs) > 0 {
+		stmts := schemaDDL(dTB.dbName, dTB.tableName, sTB.mapping.mapSource(sStruct), sTB.mapping.filterDest(dStruct), dTB.CheckDBIsTidb())
+		if err = writeDDL(p.sTable, p.dTable, stmts); err != nil {
+			logs.Error("%s.%s write ddl err:%v", dTB.dbName, dTB.tableName, err)
+		}
+	}
+	for _, d := range schemaDiffs {
+		logs.Warn("%s.%s schema %s", sTB.dbName, sTB.tableName, d)
+	}
+	if hasBlocking(schemaDiffs) {
+		r.status = statusError
+		r.err = fmt.Errorf("%s.%s schema is incompatible", sTB.dbName, sTB.tableName)
+		return
+	}
+	// 两边按源表的字段顺序对比, 目标表按映射读取
+	setColumns(sTB, dTB, cols, sStruct, dStruct)
+
+	strategy, pkCols, err := chooseKey(sTB, dTB)
+	if err != nil {
+		r.status = statusError
+		r.err = err
+		return
+	}
+	r.strategy = strategy
+	logs.Info("%s.%s key strategy: %s %v", sTB.dbName, sTB.tableName, strategy, pkCols)
+	ts := ckpt.Table(p, strategy, pkCols)
+
+	if strategy == keyMultiset {
+		// 没有可以匹配行的key, 只能对比全表, 无法给出不一致的行
+		r.checksum = keyMultiset
+		sCheckSum, err := sTB.GetMultisetCheckSum()
+		if err != nil {
+			r.status, r.err = statusError, err
+			return
+		}
+		dCheckSum, err := dTB.GetMultisetCheckSum()
+		if err != nil {
+			r.status, r.err = statusError, err
+			return
+		}
+		r.status = statusEqual
+		if sCheckSum != dCheckSum {
+			logs.Error("sCheckSum: %s dCheckSum: %s", sCheckSum, dCheckSum)
+			r.status = statusDiff
+		}
+		ckpt.FinishTable(ts, r)
+		return
+	}
+	sTB.pkCols = pkCols
+	if dTB.pkCols, err = sTB.mapping.destKey(pkCols); err != nil {
+		r.status = statusError
+		r.err = err
+		return
+	}
+
+	if isAutoIncPk && strategy == keyTidbRowid {
+		sTB.autoPk, dTB.autoPk = true, true
+	} else if isAutoIncPk {
+		// 按值分割chunk只适用于整型主键, 其他类型按主键顺序分割
+		pkType, err := dbutil.GetColumnType(sTB.db, sTB.dbName, sTB.tableName, pkCols[0])
+		if err != nil {
+			r.status = statusError
+			r.err = err
+			return
+		}
+		if dbutil.IsIntType(pkType) {
+			sTB.autoPk, dTB.autoPk = true, true
+		} else {
+			logs.Warn("%s.%s primary key %s is %s, not split chunk by value", sTB.dbName, sTB.tableName, pkCols[0], pkType)
+		}
+	}
+
+	cs, err := newChecksummer(config.AppConf.Checksum, sTB, dTB)
+	if err != nil {
+		r.status = statusError
+		r.err = err
+		return
+	}
+	r.checksum = cs.Name()
+	ts.Checksum = cs.Name()
+	logs.Info("%s.%s checksum: %s", sTB.dbName, sTB.tableName, cs.Name())
+	if _, ok := cs.(xxhashChecksummer); ok {
+		// 程序中计算hash时两边都对比规范化后的值, 对比行数据的结果与checksum一致
+		sTB.canonical, dTB.canonical = true, true
+	}
+
+	if len(sTB.shards) > 0 {
+		// 每个分片分别计算后合并
+		cs = shardChecksummer{cs}
+	}
+	if tc, ok := cs.(tableChecksummer); ok && sTB.filter == "" && sTB.where == "" && dTB.where == "" && sTB.mapping.empty() {
+		equal, err := compareTableCheckSum(tc, sTB, dTB)
+		if err != nil {
+			logs.Error("%s.%s table checksum err:%v", sTB.dbName, sTB.tableName, err)
+		}
+		if equal {
+			r.status = statusEqual
+			ckpt.FinishTable(ts, r)
+			return
+		}
+	}
+
+	countChunks := (*TableInfo).GetChunkCount
+	if config.AppConf.ChunkPlanner == plannerStats {
+		// 按统计信息分割时不读取表数据, 按EXPLAIN估算行数
+		countChunks = (*TableInfo).estimateChunkCount
+	}
+	sChunkCount, err := countChunks(sTB)
 	if err != nil {
 		logs.Error("%s.%s count chunk err:%v", sTB.dbName, sTB.tableName, err)
 	}
 
-	dChunkCount, err := dTB.GetChunkCount()
+	dChunkCount, err := countChunks(dTB)
 	if err != nil {
 		logs.Error("%s.%s count chunk err:%v", sTB.dbName, sTB.tableName, err)
 	}
@@ -114,28 +379,77 @@ func run(ctx context.Context) {
 	chunkCount := getMax(sChunkCount, dChunkCount)
 	logs.Info("chunkCount: %d", chunkCount)
 
-	if threads > chunkCount {
-		threads = chunkCount
+	// 空表也有一个不限制边界的chunk, 至少启动一个线程
+	tableThreads := getMax(getMin(threads, chunkCount), 1)
+	logs.Debug("start threads: %d, diff threads: %d", tableThreads, diffThreads)
+
+	var min, max int
+	if sTB.autoPk {
+		sMinPk, sMaxPk, err := sTB.GetMinAndMaxPk()
+		if err != nil {
+			logs.Error("diff chunk err:%v", err)
+		}
+
+		dMinPk, dMaxPk, err := dTB.GetMinAndMaxPk()
+		if err != nil {
+			logs.Error("diff chunk err:%v", err)
+		}
+		min, max = getMin(sMinPk, dMinPk), getMax(sMaxPk, dMaxPk)
+		logs.Debug("min: %d, max %d", min, max)
 	}
-	logs.Debug("start threads: %d", threads)
 
-	sMinPk, sMaxPk, err := sTB.GetMinAndMaxPk()
+	tuner = nil
+	if conf := config.AppConf; conf.ChunkAdaptive {
+		tuner = newChunkTuner(chunkSize, conf.ChunkSizeMin, conf.ChunkSizeMax, conf.ChunkLatency)
+	}
+	err = diffChunk(ctx, sTB, dTB, newSplitter(sTB, dTB, min, max, chunkCount), tableThreads, cs, ts)
+	if tuner != nil {
+		r.chunkSizes = tuner.Stats()
+		if r.chunkSizes != nil {
+			logs.Info("%s.%s chunk size: %+v", sTB.dbName, sTB.tableName, *r.chunkSizes)
+		}
+	}
+	if ctx.Err() != nil {
+		r.status = statusSkipped
+		r.err = ctx.Err()
+		return
+	}
 	if err != nil {
-		logs.Error("diff chunk err:%v", err)
+		r.status = statusError
+		r.err = fmt.Errorf("%s.%s split chunk err: %v", sTB.dbName, sTB.tableName, err)
+		return
 	}
 
-	dMinPk, dMaxPk, err := dTB.GetMinAndMaxPk()
-	if err != nil {
-		logs.Error("diff chunk err:%v", err)
+	if diffs := diffList.Rows(); len(diffs) > 0 && config.AppConf.RecheckTimes > 0 {
+		remain, err := recheckDiffs(ctx, sTB, dTB, diffs, config.AppConf.RecheckTimes, config.AppConf.RecheckInterval)
+		if err != nil {
+			logs.Error("%s.%s recheck err:%v", sTB.dbName, sTB.tableName, err)
+		} else {
+			r.resolved = len(diffs) - len(remain)
+			logs.Info("%s.%s recheck resolved: %d, remain: %d", sTB.dbName, sTB.tableName, r.resolved, len(remain))
+			resetDiffs()
+			recordDiffs(remain)
+		}
 	}
-	min, max := getMin(sMinPk, dMinPk), getMax(sMaxPk, dMaxPk)
-	logs.Debug("min: %d, max %d", min, max)
 
-	diffChunk(ctx, sTB, dTB, min, max)
+	r.insert, r.update, r.delete = len(insertList.pk), len(updateList.pk), len(deleteList.pk)
+	r.rows = diffList.Rows()
+	r.duplicate = countDiffs(r.rows, diffDuplicateKey)
+	r.status = statusEqual
+	if r.insert+r.update+r.delete+r.duplicate > 0 {
+		r.status = statusDiff
+	}
+	if n := ckpt.ChunkErrors(ts); n > 0 {
+		// 失败的chunk中可能有不一致的行, 已经找到的行仍然生成修复语句
+		r.status = statusError
+		r.err = fmt.Errorf("%s.%s %d chunks failed, run with -resume to check them again", sTB.dbName, sTB.tableName, n)
+		logs.Error("%v", r.err)
+	}
 
 	logs.Info("start create SQL")
 	err = createSQL(sTB, dTB)
 	if err != nil {
 		logs.Error("create SQL err:%v", err)
 	}
+	ckpt.FinishTable(ts, r)
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/forest11/checktable/dbutil"
+)
+
+// 数据库返回的主键顺序与程序的比较结果不一致, 例如不区分大小写的字符串主键
+var errKeyOrder = errors.New("key order not match")
+
+//rowStream 按主键顺序读取的行, 只保留当前行
+type rowStream struct {
+	rows    *sql.Rows
+	t       *TableInfo
+	n       int    // 主键字段数
+	total   int    // 主键和对比字段数
+	numeric []bool // 主键字段是否为数值
+	key     pkValue
+	vals    []interface{}
+	done    bool
+}
+
+//openRowStream 按主键顺序查询chunk的行数据
+func (t *TableInfo) openRowStream(q dbutil.Querier, chunk chunkInfo) (*rowStream, error) {
+	where, args := t.chunkWhere(chunk)
+	orderBy := dbutil.QuoteColumns(t.pkCols)
+	query := fmt.Sprintf("select %s,%s from `%s`.`%s` where %s order by %s", orderBy, t.selectCols(), t.dbName, t.tableName, where, orderBy)
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	s := &rowStream{
+		rows:    rows,
+		t:       t,
+		n:       len(t.pkCols),
+		total:   len(t.pkCols) + len(t.columns),
+		numeric: make([]bool, len(t.pkCols)),
+	}
+	for i := range s.numeric {
+		s.numeric[i] = dbutil.IsNumericType(types[i].DatabaseTypeName())
+	}
+	if err = s.next(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+//next 读取下一行, 主键没有严格递增时返回errKeyOrder
+func (s *rowStream) next() error {
+	if !s.rows.Next() {
+		s.done = true
+		return s.rows.Err()
+	}
+	vals, err := dbutil.ScanRowValues(s.rows, s.total)
+	if err != nil {
+		return err
+	}
+	key := pkValue(vals[:s.n])
+	if s.key != nil && compareKey(s.key, key, s.numeric) >= 0 {
+		return errKeyOrder
+	}
+	s.key, s.vals = key, vals[s.n:]
+	s.t.canonicalRow(s.vals)
+	return nil
+}
+
+func (s *rowStream) close() {
+	s.rows.Close()
+}
+
+//compareKey 比较两个主键的大小
+func compareKey(a, b pkValue, numeric []bool) int {
+	for i := range a {
+		if c := compareValue(a[i], b[i], numeric[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+//compareValue 比较两个字段的值, 数值按大小比较, 字符串按字节比较
+func compareValue(a, b interface{}, numeric bool) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	at, aok := a.(time.Time)
+	bt, bok := b.(time.Time)
+	if aok && bok {
+		switch {
+		case at.Before(bt):
+			return -1
+		case at.After(bt):
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	as, bs := fmt.Sprintf("%v", a), fmt.Sprintf("%v", b)
+	if numeric {
+		// 没有参数的查询使用文本协议, 数值也以字符串返回
+		ar, aok := new(big.Rat).SetString(as)
+		br, bok := new(big.Rat).SetString(bs)
+		if aok && bok {
+			return ar.Cmp(br)
+		}
+	}
+	return strings.Compare(as, bs)
+}
+
+//mergeRowDiff 两边按主键顺序读取chunk, 归并对比, 内存占用与chunk大小无关
+func mergeRowDiff(stbInfo, dtbInfo *TableInfo, chunk chunkInfo) ([]*rowDiff, error) {
+	sq, sRelease := stbInfo.querier()
+	defer sRelease()
+	dq, dRelease := dtbInfo.querier()
+	defer dRelease()
+
+	s, err := stbInfo.openRowStream(sq, chunk)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	d, err := dtbInfo.openRowStream(dq, chunk)
+	if err != nil {
+		return nil, err
+	}
+	defer d.close()
+
+	chunkStr := chunk.String()
+	pkCols, cols := stbInfo.pkCols, stbInfo.compareCols()
+	var diffs []*rowDiff
+	for !s.done || !d.done {
+		var c int
+		switch {
+		case s.done:
+			c = 1
+		case d.done:
+			c = -1
+		default:
+			c = compareKey(s.key, d.key, s.numeric)
+		}
+
+		switch {
+		case c < 0:
+			diffs = append(diffs, newRowDiff(pkCols, s.key, diffMissingInDest, chunkStr))
+			err = s.next()
+		case c > 0:
+			diffs = append(diffs, newRowDiff(pkCols, d.key, diffExtraInDest, chunkStr))
+			err = d.next()
+		default:
+			if idx := diffValues(s.vals, d.vals); len(idx) > 0 {
+				diffs = append(diffs, newValueDiff(pkCols, s.key, chunkStr, cols, s.vals, d.vals, idx))
+			}
+			if err = s.next(); err == nil {
+				err = d.next()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return diffs, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompareKey(t *testing.T) {
+	t1 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		a, b    pkValue
+		numeric []bool
+		want    int
+	}{
+		{"equal int", pkValue{int64(5)}, pkValue{int64(5)}, []bool{true}, 0},
+		{"numeric text", pkValue{"9"}, pkValue{"10"}, []bool{true}, -1},
+		{"numeric text vs int", pkValue{"10"}, pkValue{int64(9)}, []bool{true}, 1},
+		{"negative", pkValue{"-2"}, pkValue{"1"}, []bool{true}, -1},
+		{"decimal", pkValue{"1.50"}, pkValue{"1.5"}, []bool{true}, 0},
+		{"unsigned bigint", pkValue{"18446744073709551615"}, pkValue{"9223372036854775807"}, []bool{true}, 1},
+		{"string by bytes", pkValue{"10"}, pkValue{"9"}, []bool{false}, -1},
+		{"string case", pkValue{"B"}, pkValue{"a"}, []bool{false}, -1},
+		{"time", pkValue{t1}, pkValue{t1.Add(time.Second)}, []bool{false}, -1},
+		{"null first", pkValue{nil}, pkValue{"a"}, []bool{false}, -1},
+		{"null equal", pkValue{nil}, pkValue{nil}, []bool{false}, 0},
+		{"second column", pkValue{"1", "b"}, pkValue{"1", "a"}, []bool{true, false}, 1},
+		{"first column decides", pkValue{"1", "z"}, pkValue{"2", "a"}, []bool{true, false}, -1},
+	}
+	for _, tt := range tests {
+		if got := compareKey(tt.a, tt.b, tt.numeric); got != tt.want {
+			t.Errorf("%s: compareKey(%v, %v) = %d, want %d", tt.name, tt.a, tt.b, got, tt.want)
+		}
+		if got := compareKey(tt.b, tt.a, tt.numeric); got != -tt.want {
+			t.Errorf("%s: compareKey(%v, %v) = %d, want %d", tt.name, tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+//recheckDiffs 等待同步追上后只重新对比不一致的行, 返回仍然不一致的行
+func recheckDiffs(ctx context.Context, stb, dtb *TableInfo, diffs []*rowDiff, times int, interval time.Duration) ([]*rowDiff, error) {
+	for i := 1; i <= times && len(diffs) > 0; i++ {
+		logs.Info("%s.%s recheck %d/%d after %s, diff rows: %d", stb.dbName, stb.tableName, i, times, interval, len(diffs))
+		select {
+		case <-ctx.Done():
+			return diffs, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		keys := make([]pkValue, 0, len(diffs))
+		chunks := make(map[string]string, len(diffs))
+		for _, rd := range diffs {
+			keys = append(keys, rd.pk)
+			chunks[rd.pk.String()] = rd.Chunk
+		}
+
+		s, err := stb.GetRowsByKeys(keys)
+		if err != nil {
+			return diffs, err
+		}
+		d, err := dtb.GetRowsByKeys(keys)
+		if err != nil {
+			return diffs, err
+		}
+		diffs = buildRowDiffs(stb.pkCols, s, d, func(k string) string { return chunks[k] })
+	}
+	return diffs, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/forest11/checktable/dbutil"
+)
+
+//regionRange 一个region中行数据的整型handle范围, 上下界都包含
+type regionRange struct {
+	lower int64
+	upper int64
+	keys  int64
+}
+
+//regionSplitter 按tidb的region边界分割chunk, 每个chunk的数据在同一个region中, 行数超过chunkSize的region再按值均匀分割
+type regionSplitter struct {
+	t      *TableInfo // 读取region的tidb表
+	ranges []regionRange
+	max    int64
+}
+
+//newRegionSplitter 优先使用目标表的region, 只支持整型handle(_tidb_rowid或聚簇的整型主键)的非分区表
+func newRegionSplitter(stb, dtb *TableInfo) (*regionSplitter, error) {
+	t := dtb
+	if !t.CheckDBIsTidb() {
+		if len(stb.shards) > 0 || !stb.CheckDBIsTidb() {
+			return nil, errors.New("neither side is tidb")
+		}
+		t = stb
+	}
+	if err := checkIntHandle(t); err != nil {
+		return nil, err
+	}
+	ids, err := dbutil.GetTiDBTableIDs(t.db, t.dbName, t.tableName)
+	if err != nil {
+		return nil, fmt.Errorf("get table id err: %v", err)
+	}
+	if len(ids) != 1 {
+		return nil, errors.New("partitioned table is not supported")
+	}
+	regions, err := dbutil.GetTableRegions(t.db, t.dbName, t.tableName)
+	if err != nil {
+		return nil, fmt.Errorf("show table regions err: %v", err)
+	}
+	min, max, err := t.minAndMaxPk()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &regionSplitter{t: t, max: max.Int64}
+	if !min.Valid {
+		return s, nil
+	}
+	for _, r := range regions {
+		rr, ok, err := regionRecords(r, ids[0], min.Int64, max.Int64)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.ranges = append(s.ranges, rr)
+		}
+	}
+	sort.Slice(s.ranges, func(i, j int) bool { return s.ranges[i].lower < s.ranges[j].lower })
+	logs.Info("%s.%s regions: %d, regions with records: %d", t.dbName, t.tableName, len(regions), len(s.ranges))
+	return s, nil
+}
+
+//checkIntHandle 行数据的key为整型handle时region边界才能转换为key的值
+func checkIntHandle(t *TableInfo) error {
+	if len(t.pkCols) != 1 {
+		return fmt.Errorf("key (%s) is not an int handle", dbutil.QuoteColumns(t.pkCols))
+	}
+	if t.pkCols[0] == tidbRowidCol {
+		return nil
+	}
+	pkCols, err := dbutil.GetPKColumns(t.db, t.dbName, t.tableName)
+	if err != nil {
+		return err
+	}
+	if len(pkCols) != 1 || pkCols[0] != t.pkCols[0] {
+		return fmt.Errorf("key %s is not the primary key", t.pkCols[0])
+	}
+	pkType, err := dbutil.GetColumnType(t.db, t.dbName, t.tableName, pkCols[0])
+	if err != nil {
+		return err
+	}
+	if !dbutil.IsIntType(pkType) {
+		return fmt.Errorf("primary key %s is %s", pkCols[0], pkType)
+	}
+	clustered, err := dbutil.IsTiDBClustered(t.db, t.dbName, t.tableName)
+	if err != nil {
+		return fmt.Errorf("get primary key type err: %v", err)
+	}
+	if !clustered {
+		return errors.New("primary key is nonclustered")
+	}
+	return nil
+}
+
+//regionRecords region中行数据的handle范围, 限制在表中key的最小值和最大值之间, 没有行数据时返回false
+func regionRecords(r dbutil.TableRegion, tableID, min, max int64) (regionRange, bool, error) {
+	rr := regionRange{lower: min, upper: max, keys: r.Keys}
+	pos, handle, err := dbutil.DecodeRecordKey(r.StartKey, tableID, false)
+	if err != nil {
+		return rr, false, err
+	}
+	switch pos {
+	case dbutil.KeyAfterRecords:
+		return rr, false, nil
+	case dbutil.KeyInRecords:
+		if handle > rr.lower {
+			rr.lower = handle
+		}
+	}
+
+	pos, handle, err = dbutil.DecodeRecordKey(r.EndKey, tableID, true)
+	if err != nil {
+		return rr, false, err
+	}
+	switch pos {
+	case dbutil.KeyBeforeRecords:
+		return rr, false, nil
+	case dbutil.KeyInRecords:
+		// end key不包含在region中
+		if handle-1 < rr.upper {
+			rr.upper = handle - 1
+		}
+	}
+	return rr, rr.lower <= rr.upper, nil
+}
+
+//Name 分割方式
+func (s *regionSplitter) Name() string {
+	return fmt.Sprintf("region(%s.%s)", s.t.dbName, s.t.tableName)
+}
+
+//Split 分割chunk, region的上界作为chunk的边界, 行数超过chunk大小的region按估算的行数均匀分割
+func (s *regionSplitter) Split(ctx context.Context, chunks chan<- chunkInfo) error {
+	var lower []interface{}
+	for _, r := range s.ranges {
+		// 自适应时每个region使用当前的chunk大小
+		size := nextChunkSize()
+		span := r.upper - r.lower + 1
+		parts := (r.keys + int64(size) - 1) / int64(size)
+		if parts < 1 {
+			parts = 1
+		}
+		if parts > span {
+			parts = span
+		}
+		step := (span + parts - 1) / parts
+		for b := r.lower - 1 + step; ; b += step {
+			if b > r.upper {
+				b = r.upper
+			}
+			if b >= s.max {
+				break
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			upper := []interface{}{b}
+			chunk := newChunkInfo(lower, upper)
+			chunk.size = size
+			chunks <- chunk
+			lower = upper
+			if b == r.upper {
+				break
+			}
+		}
+	}
+	chunk := newChunkInfo(lower, nil)
+	chunk.size = nextChunkSize()
+	chunks <- chunk
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/forest11/checktable/dbutil"
+)
+
+func TestRegionRecords(t *testing.T) {
+	tests := []struct {
+		name   string
+		region dbutil.TableRegion
+		want   regionRange
+		ok     bool
+	}{
+		{"first region", dbutil.TableRegion{StartKey: "t_75_", EndKey: "t_75_r_2000", Keys: 1999}, regionRange{1, 1999, 1999}, true},
+		{"empty start key", dbutil.TableRegion{StartKey: "", EndKey: "t_75_r_10", Keys: 9}, regionRange{1, 9, 9}, true},
+		{"last region", dbutil.TableRegion{StartKey: "t_75_r_2000", EndKey: "t_76_", Keys: 10}, regionRange{2000, 9000, 10}, true},
+		{"empty end key", dbutil.TableRegion{StartKey: "t_75_r_8000", EndKey: "", Keys: 10}, regionRange{8000, 9000, 10}, true},
+		{"index region", dbutil.TableRegion{StartKey: "t_75_", EndKey: "t_75_i_1_abc", Keys: 10}, regionRange{}, false},
+		{"previous table", dbutil.TableRegion{StartKey: "t_74_r_1", EndKey: "t_75_r_", Keys: 10}, regionRange{}, false},
+		{"next table", dbutil.TableRegion{StartKey: "t_76_", EndKey: "t_77_", Keys: 10}, regionRange{}, false},
+		{"after max", dbutil.TableRegion{StartKey: "t_75_r_9500", EndKey: "t_76_", Keys: 10}, regionRange{}, false},
+	}
+	for _, tt := range tests {
+		got, ok, err := regionRecords(tt.region, 75, 1, 9000)
+		if err != nil {
+			t.Errorf("%s: err %v", tt.name, err)
+			continue
+		}
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("%s: got %v %v, want %v %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func splitBounds(t *testing.T, s chunkSplitter) []string {
+	chunks := make(chan chunkInfo, 100)
+	if err := s.Split(context.Background(), chunks); err != nil {
+		t.Fatal(err)
+	}
+	close(chunks)
+	var got []string
+	for c := range chunks {
+		got = append(got, c.String())
+	}
+	return got
+}
+
+func TestRegionSplit(t *testing.T) {
+	oldSize, oldTuner := chunkSize, tuner
+	t.Cleanup(func() { chunkSize, tuner = oldSize, oldTuner })
+	chunkSize, tuner = 1000, nil
+	s := &regionSplitter{
+		t:   &TableInfo{},
+		max: 9000,
+		ranges: []regionRange{
+			{lower: 1, upper: 1999, keys: 1999},
+			// 超过chunkSize的region按值分割, 最后一个边界达到max时不单独生成chunk
+			{lower: 2000, upper: 9000, keys: 2500},
+		},
+	}
+	want := []string{
+		`(-inf, "1000"]`,
+		`("1000", "1999"]`,
+		`("1999", "4333"]`,
+		`("4333", "6667"]`,
+		`("6667", +inf]`,
+	}
+	got := splitBounds(t, s)
+	if len(got) != len(want) {
+		t.Fatalf("chunks %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("chunk %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	empty := splitBounds(t, &regionSplitter{t: &TableInfo{}})
+	if len(empty) != 1 || empty[0] != "(-inf, +inf]" {
+		t.Errorf("empty table chunks %v", empty)
+	}
+}
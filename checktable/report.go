@@ -4,36 +4,147 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/astaxie/beego/logs"
 	"github.com/forest11/checktable/config"
+	"github.com/forest11/checktable/dbutil"
 )
 
-//目标库获取数据
-func getData(list []string, db *TableInfo) {
-	listChunk, rem := len(list)/100, len(list)%100
-	if listChunk > 0 {
-		for i := 0; i < listChunk; i++ {
-			s := strings.Join(list[i*100:(i+1)*100], ",")
-			sqlStr := fmt.Sprintf("%s -u%s -p%s -h%s -P%s --single-transaction --compact --set-gtid-purged=OFF -t -B %s --tables %s --where='%s in (%s)'", config.AppConf.MysqlDump, config.AppConf.SourceDB.User, config.AppConf.SourceDB.Pwd, config.AppConf.SourceDB.Addr, config.AppConf.SourceDB.Port, db.dbName, db.tableName, db.pkName, s)
+const fixBatchSize = 100
 
-			ret, err := execShell("sh", "-c", sqlStr)
+//writeManualFix 无法生成修复语句的行, 写入注释, 需要人工修复
+func writeManualFix(dDb *TableInfo, list []pkValue, reason string) error {
+	logs.Warn("%s.%s %d rows need manual fix: %s", dDb.dbName, dDb.tableName, len(list), reason)
+	var lines []string
+	for _, k := range list {
+		lines = append(lines, fmt.Sprintf("-- manual fix needed: `%s`.`%s` WHERE %s; %s\n", dDb.dbName, dDb.tableName, dbutil.KeyEqualWhere(dDb.pkCols, k), reason))
+	}
+	return writeFile(config.AppConf.DumpFile, strings.Join(lines, ""))
+}
+
+//源库获取数据, 按字段映射生成目标表的INSERT ... ON DUPLICATE KEY UPDATE语句, 不覆盖目标表中没有映射的字段
+//字段映射为表达式或者目标表有不对比的字段时, 无法生成完整的行, 只写入需要人工修复的注释
+func getData(list []pkValue, sDb, dDb *TableInfo) error {
+	if cols := sDb.mapping.unfixable(); len(cols) > 0 {
+		return writeManualFix(dDb, list, fmt.Sprintf("missing_in_dest, columns can not be rebuilt from source: %s", strings.Join(cols, ",")))
+	}
+	colsStr, err := dbutil.GetTableFieldStr(sDb.db, sDb.dbName, sDb.tableName, "")
+	if err != nil {
+		return err
+	}
+	sCols, dCols := sDb.mapping.fixColumns(strings.Split(colsStr, ","))
+	cols := dbutil.QuoteColumns(sCols)
+	updates := make([]string, 0, len(dCols))
+	for _, c := range dCols {
+		updates = append(updates, fmt.Sprintf("`%s`=VALUES(`%s`)", c, c))
+	}
+
+	for i := 0; i < len(list); i += fixBatchSize {
+		batch := list[i:getMin(i+fixBatchSize, len(list))]
+		where, args := dbutil.KeyInWhere(sDb.pkCols, pkValues(batch))
+		// 分片合并时从每个分片读取
+		var values []string
+		for _, st := range sDb.tables() {
+			query := fmt.Sprintf("select %s from `%s`.`%s` where %s", cols, st.dbName, st.tableName, where)
+			rows, err := st.db.Query(query, args...)
 			if err != nil {
-				panic(fmt.Sprintf("mysqldump args is err: %v", err))
+				return err
+			}
+
+			for rows.Next() {
+				_, literals, err := dbutil.ScanRowLiterals(rows)
+				if err != nil {
+					rows.Close()
+					return err
+				}
+				values = append(values, fmt.Sprintf("(%s)", strings.Join(literals, ",")))
+			}
+			rows.Close()
+			if err = rows.Err(); err != nil {
+				return err
 			}
-			r := strings.Replace(ret, "INSERT", "REPLACE", -1)
-			writeFile(config.AppConf.DumpFile, r)
 		}
+		if len(values) == 0 {
+			continue
+		}
+
+		insertSQL := fmt.Sprintf("INSERT INTO `%s`.`%s` (%s) VALUES %s ON DUPLICATE KEY UPDATE %s;\n",
+			dDb.dbName, dDb.tableName, dbutil.QuoteColumns(dCols), strings.Join(values, ","), strings.Join(updates, ","))
+		if err := writeFile(config.AppConf.DumpFile, insertSQL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//源库获取数据, 生成目标表只更新不一致字段的UPDATE语句
+func getUpdateData(list []pkValue, diffCols map[string][]string, sDb, dDb *TableInfo) error {
+	colsStr, err := dbutil.GetTableFieldStr(sDb.db, sDb.dbName, sDb.tableName, "")
+	if err != nil {
+		return err
 	}
-	if rem != 0 {
-		s := strings.Join(list[listChunk*100:listChunk*100+rem], ",")
-		sqlStr := fmt.Sprintf("%s -u%s -p%s -h%s -P%s --single-transaction --compact --set-gtid-purged=OFF -t -B %s --tables %s --where='%s in (%s)'", config.AppConf.MysqlDump, config.AppConf.SourceDB.User, config.AppConf.SourceDB.Pwd, config.AppConf.SourceDB.Addr, config.AppConf.SourceDB.Port, db.dbName, db.tableName, db.pkName, s)
+	cols, dCols := sDb.mapping.fixColumns(strings.Split(colsStr, ","))
+	colIdx := make(map[string]int, len(cols))
+	for i, c := range cols {
+		colIdx[c] = i
+	}
+	n := len(sDb.pkCols)
+
+	var manualCount int
+	for i := 0; i < len(list); i += fixBatchSize {
+		batch := list[i:getMin(i+fixBatchSize, len(list))]
+		where, args := dbutil.KeyInWhere(sDb.pkCols, pkValues(batch))
+		var updates []string
+		for _, st := range sDb.tables() {
+			query := fmt.Sprintf("select %s,%s from `%s`.`%s` where %s", dbutil.QuoteColumns(st.pkCols), dbutil.QuoteColumns(cols), st.dbName, st.tableName, where)
+			rows, err := st.db.Query(query, args...)
+			if err != nil {
+				return err
+			}
 
-		ret, err := execShell("sh", "-c", sqlStr)
-		if err != nil {
-			panic(fmt.Sprintf("mysqldump args is err: %v", err))
+			for rows.Next() {
+				vals, literals, err := dbutil.ScanRowLiterals(rows)
+				if err != nil {
+					rows.Close()
+					return err
+				}
+				key := pkValue(vals[:n])
+
+				var sets, manual []string
+				for _, c := range diffCols[key.String()] {
+					c = strings.TrimSpace(c)
+					idx, ok := colIdx[c]
+					if !ok {
+						// 映射为表达式的字段不能从源表的值写入
+						manual = append(manual, c)
+						continue
+					}
+					sets = append(sets, fmt.Sprintf("`%s`=%s", dCols[idx], literals[n+idx]))
+				}
+				if len(manual) > 0 {
+					manualCount++
+					updates = append(updates, fmt.Sprintf("-- manual fix needed: `%s`.`%s` WHERE %s; value_mismatch on columns mapped to expressions: %s\n",
+						dDb.dbName, dDb.tableName, dbutil.KeyEqualWhere(dDb.pkCols, key), strings.Join(manual, ",")))
+				}
+				if len(sets) == 0 {
+					continue
+				}
+				updates = append(updates, fmt.Sprintf("UPDATE `%s`.`%s` SET %s WHERE %s;\n",
+					dDb.dbName, dDb.tableName, strings.Join(sets, ","), dbutil.KeyEqualWhere(dDb.pkCols, key)))
+			}
+			rows.Close()
+			if err = rows.Err(); err != nil {
+				return err
+			}
+		}
+
+		if err := writeFile(config.AppConf.DumpFile, strings.Join(updates, "")); err != nil {
+			return err
 		}
-		r := strings.Replace(ret, "INSERT", "REPLACE", -1)
-		writeFile(config.AppConf.DumpFile, r)
 	}
+	if manualCount > 0 {
+		logs.Warn("%s.%s %d rows need manual fix: value_mismatch on columns mapped to expressions", dDb.dbName, dDb.tableName, manualCount)
+	}
+	return nil
 }
 
 //生成sql语句
@@ -41,27 +152,33 @@ func createSQL(sDb, dDb *TableInfo) error {
 	if len(deleteList.pk) > 0 {
 		if config.AppConf.Dump {
 			for _, v := range deleteList.pk {
-				delSQL := fmt.Sprintf("delete from %s where %s=%v;\n", dDb.tableName, dDb.pkName, v)
-				writeFile(config.AppConf.DumpFile, delSQL)
+				delSQL := fmt.Sprintf("DELETE FROM `%s`.`%s` WHERE %s;\n", dDb.dbName, dDb.tableName, dbutil.KeyEqualWhere(dDb.pkCols, v))
+				if err := writeFile(config.AppConf.DumpFile, delSQL); err != nil {
+					return err
+				}
 			}
 		} else {
-			return fmt.Errorf("source table(%s) has no data: list: %v", sDb.tableName, deleteList.pk)
+			return fmt.Errorf("source table(%s) has no data: %d rows", sDb.tableName, len(deleteList.pk))
 		}
 	}
 
 	if len(insertList.pk) > 0 {
 		if config.AppConf.Dump && len(insertList.pk) < 10000 {
-			getData(insertList.pk, sDb)
+			if err := getData(insertList.pk, sDb, dDb); err != nil {
+				return err
+			}
 		} else {
-			return fmt.Errorf("dest table(%s) has no data: list: %v", dDb.tableName, insertList.pk)
+			return fmt.Errorf("dest table(%s) has no data: %d rows", dDb.tableName, len(insertList.pk))
 		}
 	}
 
 	if len(updateList.pk) > 0 {
 		if config.AppConf.Dump && len(updateList.pk) < 10000 {
-			getData(updateList.pk, sDb)
+			if err := getUpdateData(updateList.pk, updateList.cols, sDb, dDb); err != nil {
+				return err
+			}
 		} else {
-			return fmt.Errorf("table(%s) field data is diff: list: %v", dDb.tableName, updateList.pk)
+			return fmt.Errorf("table(%s) field data is diff: %d rows", dDb.tableName, len(updateList.pk))
 		}
 	}
 
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/forest11/checktable/config"
+)
+
+const (
+	diffMissingInDest = "missing_in_dest"
+	diffExtraInDest   = "extra_in_dest"
+	diffValueMismatch = "value_mismatch"
+	diffDuplicateKey  = "duplicate_in_source" // 分片合并时多个分片中有相同key的行
+)
+
+//columnDiff 不一致的字段
+type columnDiff struct {
+	Name   string      `json:"name"`
+	Source interface{} `json:"source"`
+	Dest   interface{} `json:"dest"`
+}
+
+//rowDiff 不一致的行
+type rowDiff struct {
+	Key     map[string]interface{} `json:"key"`
+	Kind    string                 `json:"kind"`
+	Columns []columnDiff           `json:"columns,omitempty"`
+	Shards  []string               `json:"shards,omitempty"` // 重复的行所在的分片
+	Chunk   string                 `json:"chunk"`
+
+	pk      pkValue
+	keyCols []string
+}
+
+//rowDiffList 单表所有不一致的行
+type rowDiffList struct {
+	rows []*rowDiff
+	rw   sync.RWMutex
+}
+
+var diffList rowDiffList
+
+//Add 添加不一致的行
+func (l *rowDiffList) Add(rows ...*rowDiff) {
+	l.rw.Lock()
+	defer l.rw.Unlock()
+	l.rows = append(l.rows, rows...)
+}
+
+//Reset 清空, 开始校验新表
+func (l *rowDiffList) Reset() {
+	l.rw.Lock()
+	defer l.rw.Unlock()
+	l.rows = make([]*rowDiff, 0)
+}
+
+//Rows 返回所有不一致的行
+func (l *rowDiffList) Rows() []*rowDiff {
+	l.rw.RLock()
+	defer l.rw.RUnlock()
+	return l.rows
+}
+
+//newRowDiff 创建不一致的行
+func newRowDiff(pkCols []string, key pkValue, kind string, chunk string) *rowDiff {
+	r := &rowDiff{
+		Key:     make(map[string]interface{}, len(pkCols)),
+		Kind:    kind,
+		Chunk:   chunk,
+		pk:      key,
+		keyCols: pkCols,
+	}
+	for i, c := range pkCols {
+		r.Key[c] = reportValue(key[i])
+	}
+	return r
+}
+
+//countDiffs 统计某种不一致的行数
+func countDiffs(rows []*rowDiff, kind string) int {
+	var n int
+	for _, r := range rows {
+		if r.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+//keyString 主键转换为 a=1,b=2
+func (r *rowDiff) keyString() string {
+	kv := make([]string, 0, len(r.keyCols))
+	for _, c := range r.keyCols {
+		kv = append(kv, fmt.Sprintf("%s=%v", c, r.Key[c]))
+	}
+	return strings.Join(kv, ",")
+}
+
+//reportValue 转换为报告中的值, NULL为nil, 非utf8的二进制数据为十六进制
+func reportValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		return x.Format("2006-01-02 15:04:05.999999")
+	case string:
+		if !utf8.ValidString(x) {
+			return "0x" + hex.EncodeToString([]byte(x))
+		}
+		return x
+	default:
+		return fmt.Sprintf("%v", x)
+	}
+}
+
+//tableReport json报告中的单表结果
+type tableReport struct {
+	SourceTable string          `json:"source_table"`
+	DestTable   string          `json:"dest_table"`
+	Key         string          `json:"key"`
+	Checksum    string          `json:"checksum"`
+	Status      string          `json:"status"`
+	Insert      int             `json:"missing_in_dest"`
+	Update      int             `json:"value_mismatch"`
+	Delete      int             `json:"extra_in_dest"`
+	Duplicate   int             `json:"duplicate_in_source"`
+	Resolved    int             `json:"resolved"`
+	Cost        string          `json:"cost"`
+	Error       string          `json:"error,omitempty"`
+	Schema      []schemaDiff    `json:"schema_diffs,omitempty"`
+	ChunkSizes  *chunkSizeStats `json:"chunk_sizes,omitempty"`
+	Rows        []*rowDiff      `json:"rows"`
+}
+
+//runReport json报告
+type runReport struct {
+	StartTime string         `json:"start_time"`
+	EndTime   string         `json:"end_time"`
+	SourceDB  string         `json:"source_db"`
+	DestDB    string         `json:"dest_db"`
+	Tables    []*tableReport `json:"tables"`
+}
+
+//writeReport 输出json和csv报告
+func (s *checkSummary) writeReport(start time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+
+	report := &runReport{
+		StartTime: start.Format(time.RFC3339),
+		EndTime:   time.Now().Format(time.RFC3339),
+		SourceDB:  config.AppConf.SourceDB.DBName,
+		DestDB:    config.AppConf.DestDB.DBName,
+		Tables:    make([]*tableReport, 0, len(s.results)),
+	}
+	for _, r := range s.results {
+		t := &tableReport{
+			SourceTable: r.sTable,
+			DestTable:   r.dTable,
+			Key:         r.strategy,
+			Checksum:    r.checksum,
+			Status:      r.status,
+			Insert:      r.insert,
+			Update:      r.update,
+			Delete:      r.delete,
+			Duplicate:   r.duplicate,
+			Resolved:    r.resolved,
+			Cost:        r.cost.String(),
+			Schema:      r.schema,
+			ChunkSizes:  r.chunkSizes,
+			Rows:        r.rows,
+		}
+		if r.err != nil {
+			t.Error = r.err.Error()
+		}
+		if t.Rows == nil {
+			t.Rows = make([]*rowDiff, 0)
+		}
+		report.Tables = append(report.Tables, t)
+	}
+
+	if config.AppConf.ReportJSON != "" {
+		if err := writeJSONReport(config.AppConf.ReportJSON, report); err != nil {
+			return err
+		}
+	}
+	if config.AppConf.ReportCSV != "" {
+		if err := writeCSVReport(config.AppConf.ReportCSV, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSONReport(fileName string, report *runReport) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+//writeCSVReport 每个不一致的字段一行, 缺失或多出的行只有一行
+func writeCSVReport(fileName string, report *runReport) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"source_table", "dest_table", "key", "kind", "chunk", "column", "source_value", "dest_value"})
+	for _, t := range report.Tables {
+		for _, r := range t.Rows {
+			if len(r.Columns) == 0 {
+				// 重复的行在source_value中输出所在的分片
+				w.Write([]string{t.SourceTable, t.DestTable, r.keyString(), r.Kind, r.Chunk, "", strings.Join(r.Shards, ","), ""})
+				continue
+			}
+			for _, c := range r.Columns {
+				w.Write([]string{t.SourceTable, t.DestTable, r.keyString(), r.Kind, r.Chunk, c.Name, csvValue(c.Source), csvValue(c.Dest)})
+			}
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+//csvValue csv中NULL输出为\N
+func csvValue(v interface{}) string {
+	if v == nil {
+		return `\N`
+	}
+	return fmt.Sprintf("%v", v)
+}
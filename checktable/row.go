@@ -8,16 +8,12 @@ import (
 	"github.com/forest11/checktable/dbutil"
 )
 
-//GetMinAndMaxPk 获取最大主键，最小主键
+//GetMinAndMaxPk 获取最大主键，最小主键, 复合主键取第一个字段
 func (t *TableInfo) GetMinAndMaxPk() (int, int, error) {
-	where := t.where
-	if where == "" {
-		where = "true"
+	if len(t.shards) > 0 {
+		return t.shardMinAndMaxPk()
 	}
-	query := fmt.Sprintf("select min(`%s`) as min, max(`%s`) as  max from `%s`.`%s` where %s", t.pkName, t.pkName, t.dbName, t.tableName, where)
-
-	var min, max sql.NullInt64
-	err := t.db.QueryRow(query).Scan(&min, &max)
+	min, max, err := t.minAndMaxPk()
 	if err != nil {
 		return 0, 0, err
 	}
@@ -29,6 +25,21 @@ func (t *TableInfo) GetMinAndMaxPk() (int, int, error) {
 	return int(min.Int64), int(max.Int64), nil
 }
 
+//minAndMaxPk 查询主键第一个字段的最小值和最大值, 没有数据时为NULL
+func (t *TableInfo) minAndMaxPk() (sql.NullInt64, sql.NullInt64, error) {
+	where := t.where
+	if where == "" {
+		where = "true"
+	}
+	query := fmt.Sprintf("select min(`%s`) as min, max(`%s`) as  max from `%s`.`%s` where %s", t.pkCols[0], t.pkCols[0], t.dbName, t.tableName, where)
+
+	q, release := t.querier()
+	defer release()
+	var min, max sql.NullInt64
+	err := q.QueryRow(query).Scan(&min, &max)
+	return min, max, err
+}
+
 //GetRowCount 获取行的总数
 func (t *TableInfo) GetRowCount() (int, error) {
 	/*
@@ -39,14 +50,27 @@ func (t *TableInfo) GetRowCount() (int, error) {
 	   |    2 |
 	   +------+
 	*/
+	if len(t.shards) > 0 {
+		var total int
+		for _, st := range t.tables() {
+			cnt, err := st.GetRowCount()
+			if err != nil {
+				return 0, err
+			}
+			total += cnt
+		}
+		return total, nil
+	}
 	where := t.where
 	if where == "" {
 		where = "true"
 	}
 	query := fmt.Sprintf("select count(*) as cnt from `%s`.`%s` where %s", t.dbName, t.tableName, where)
 
+	q, release := t.querier()
+	defer release()
 	var cnt sql.NullInt64
-	err := t.db.QueryRow(query).Scan(&cnt)
+	err := q.QueryRow(query).Scan(&cnt)
 	if err != nil {
 		return 0, err
 	}
@@ -57,61 +81,213 @@ func (t *TableInfo) GetRowCount() (int, error) {
 	return int(cnt.Int64), nil
 }
 
-//GetRangeRowData 根据主键范围获取行数据
-func (t *TableInfo) GetRangeRowData(pkStart, pkEnd int) (map[string]string, error) {
-	fieldStr, err := dbutil.GetTableFieldStr(t.db, t.dbName, t.tableName, t.filter)
-	if err != nil {
-		return nil, err
+//GetRangeRowCount 获取主键范围内的行数
+func (t *TableInfo) GetRangeRowCount(chunk chunkInfo) (int, error) {
+	if len(t.shards) > 0 {
+		var total int
+		for _, st := range t.tables() {
+			cnt, err := st.GetRangeRowCount(chunk)
+			if err != nil {
+				return 0, err
+			}
+			total += cnt
+		}
+		return total, nil
 	}
+	where, args := t.chunkWhere(chunk)
+	query := fmt.Sprintf("select count(*) as cnt from `%s`.`%s` where %s", t.dbName, t.tableName, where)
 
-	where := fmt.Sprintf("%s >= %d and %s <= %d", t.pkName, pkStart, t.pkName, pkEnd)
-	if t.where != "" && isAutoIncPk == false {
-		where = fmt.Sprintf("%s and %s", t.where, where)
+	q, release := t.querier()
+	defer release()
+	var cnt int
+	if err := q.QueryRow(query, args...).Scan(&cnt); err != nil {
+		return 0, err
 	}
+	return cnt, nil
+}
 
-	query := fmt.Sprintf("select %s,CONCAT_WS('#', %s) as cw from `%s`.`%s` where %s", t.pkName, fieldStr, t.dbName, t.tableName, where)
-	rows, err := t.db.Query(query)
+//rowSet chunk内的行数据
+type rowSet struct {
+	cols   []string                 // 对比的字段
+	data   map[string][]interface{} // 编码后的主键 => 每个字段的值
+	keys   map[string]pkValue       // 编码后的主键 => 主键值
+	shards map[string][]string      // 分片合并时, 编码后的主键 => 所在的分片
+}
+
+//queryRowSet 查询行数据加入rs
+func (t *TableInfo) queryRowSet(q dbutil.Querier, rs *rowSet, where string, args []interface{}) error {
+	query := fmt.Sprintf("select %s,%s from `%s`.`%s` where %s", dbutil.QuoteColumns(t.pkCols), t.selectCols(), t.dbName, t.tableName, where)
+	rows, err := q.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer rows.Close()
 
-	queryList, err := dbutil.ScanRowToMapStr(rows)
-	if err != nil {
-		return nil, err
+	n := len(t.pkCols)
+	for rows.Next() {
+		vals, err := dbutil.ScanRowValues(rows, n+len(rs.cols))
+		if err != nil {
+			return err
+		}
+		key := pkValue(vals[:n])
+		k := key.String()
+		rs.keys[k] = key
+		rs.data[k] = vals[n:]
+		if t.label != "" {
+			rs.shards[k] = append(rs.shards[k], t.label)
+		}
+		t.canonicalRow(rs.data[k])
 	}
-	return queryList, nil
+	return rows.Err()
 }
 
-//DiffRowData 找出不同行数据
-func DiffRowData(stbInfo, dtbInfo *TableInfo, chunk chunkInfo) error {
-	s, err := stbInfo.GetRangeRowData(chunk.pkStart, chunk.pkEnd)
+func newRowSet(cols []string) *rowSet {
+	return &rowSet{
+		cols:   cols,
+		data:   make(map[string][]interface{}),
+		keys:   make(map[string]pkValue),
+		shards: make(map[string][]string),
+	}
+}
+
+//GetRangeRowData 根据主键范围获取行数据, 分片合并时读取所有分片
+func (t *TableInfo) GetRangeRowData(chunk chunkInfo) (*rowSet, error) {
+	rs := newRowSet(t.compareCols())
+	for _, st := range t.tables() {
+		where, args := st.chunkWhere(chunk)
+
+		q, release := st.querier()
+		err := st.queryRowSet(q, rs, where, args)
+		release()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rs, nil
+}
+
+//GetRowsByKeys 根据主键获取最新的行数据, 不使用快照
+func (t *TableInfo) GetRowsByKeys(keys []pkValue) (*rowSet, error) {
+	rs := newRowSet(t.compareCols())
+	for i := 0; i < len(keys); i += fixBatchSize {
+		batch := keys[i:getMin(i+fixBatchSize, len(keys))]
+		where, args := dbutil.KeyInWhere(t.pkCols, pkValues(batch))
+		where = t.filterWhere(where)
+		for _, st := range t.tables() {
+			if err := st.queryRowSet(st.db, rs, where, args); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rs, nil
+}
+
+//buildRowDiffs 对比两边的行数据, 生成不一致的行
+func buildRowDiffs(pkCols []string, s, d *rowSet, chunkOf func(k string) string) []*rowDiff {
+	sNoKey, dNoKey, diffValueKey, diffCols := diffRowMap(s.data, d.data)
+	var diffs []*rowDiff
+	for _, k := range dNoKey {
+		diffs = append(diffs, newRowDiff(pkCols, s.keys[k], diffMissingInDest, chunkOf(k)))
+	}
+	for _, k := range sNoKey {
+		diffs = append(diffs, newRowDiff(pkCols, d.keys[k], diffExtraInDest, chunkOf(k)))
+	}
+	for _, k := range diffValueKey {
+		diffs = append(diffs, newValueDiff(pkCols, s.keys[k], chunkOf(k), s.cols, s.data[k], d.data[k], diffCols[k]))
+	}
+	for k, shards := range s.shards {
+		if len(shards) > 1 {
+			rd := newRowDiff(pkCols, s.keys[k], diffDuplicateKey, chunkOf(k))
+			rd.Shards = shards
+			diffs = append(diffs, rd)
+		}
+	}
+	return diffs
+}
+
+//newValueDiff 生成字段不一致的行, idx为不一致字段的下标
+func newValueDiff(pkCols []string, key pkValue, chunk string, cols []string, sv, dv []interface{}, idx []int) *rowDiff {
+	rd := newRowDiff(pkCols, key, diffValueMismatch, chunk)
+	for _, i := range idx {
+		var d interface{}
+		if i < len(dv) {
+			d = dv[i]
+		}
+		rd.Columns = append(rd.Columns, columnDiff{Name: cols[i], Source: reportValue(sv[i]), Dest: reportValue(d)})
+	}
+	return rd
+}
+
+//mapRowDiff 读取chunk的全部行到map中对比
+func mapRowDiff(stbInfo, dtbInfo *TableInfo, chunk chunkInfo) ([]*rowDiff, error) {
+	s, err := stbInfo.GetRangeRowData(chunk)
 	if err != nil {
-		return fmt.Errorf("sCheckSum GetRangeRowData err: %v", err)
+		return nil, fmt.Errorf("sCheckSum GetRangeRowData err: %v", err)
 	}
-	logs.Debug("source row data: %v", s)
+	logs.Debug("source row data: %v", len(s.data))
 
-	d, err := dtbInfo.GetRangeRowData(chunk.pkStart, chunk.pkEnd)
+	d, err := dtbInfo.GetRangeRowData(chunk)
 	if err != nil {
-		return fmt.Errorf("dCheckSum GetRangeRowData err: %v", err)
+		return nil, fmt.Errorf("dCheckSum GetRangeRowData err: %v", err)
 	}
-	logs.Debug("dest row data: %v", d)
+	logs.Debug("dest row data: %v", len(d.data))
+
+	chunkStr := chunk.String()
+	return buildRowDiffs(stbInfo.pkCols, s, d, func(string) string { return chunkStr }), nil
+}
 
-	sNoKey, dNoKey, diffValueKey := diffMap(s, d)
-	if len(dNoKey) > 0 {
-		insertList.rw.Lock()
-		insertList.pk = append(insertList.pk, dNoKey...)
-		insertList.rw.Unlock()
+//DiffRowData 找出不同行数据, 默认按主键顺序流式对比, 数据库的排序与程序不一致或者分片合并时读取到map中对比
+func DiffRowData(stbInfo, dtbInfo *TableInfo, chunk chunkInfo) ([]*rowDiff, error) {
+	var diffs []*rowDiff
+	var err error
+	if len(stbInfo.shards) > 0 {
+		// 分片合并时读取所有分片到map中, 同时找出分片之间重复的行
+		diffs, err = mapRowDiff(stbInfo, dtbInfo, chunk)
+	} else {
+		diffs, err = mergeRowDiff(stbInfo, dtbInfo, chunk)
+		if err == errKeyOrder {
+			logs.Warn("chunk %s key order not match, diff rows in map", chunk)
+			diffs, err = mapRowDiff(stbInfo, dtbInfo, chunk)
+		}
 	}
-	if len(sNoKey) > 0 {
-		deleteList.rw.Lock()
-		deleteList.pk = append(deleteList.pk, sNoKey...)
-		deleteList.rw.Unlock()
+	if err != nil {
+		return nil, err
 	}
-	if len(diffValueKey) > 0 {
-		updateList.rw.Lock()
-		updateList.pk = append(updateList.pk, diffValueKey...)
-		updateList.rw.Unlock()
+	recordDiffs(diffs)
+	logs.Debug("DiffRowData:\n insertList:%v \n deleteList:%v \n updateList:%v", len(insertList.pk), len(deleteList.pk), len(updateList.pk))
+	return diffs, nil
+}
+
+//recordDiffs 不一致的行加入insertList/deleteList/updateList和报告
+func recordDiffs(diffs []*rowDiff) {
+	for _, rd := range diffs {
+		switch rd.Kind {
+		case diffMissingInDest:
+			insertList.rw.Lock()
+			insertList.pk = append(insertList.pk, rd.pk)
+			insertList.rw.Unlock()
+		case diffExtraInDest:
+			deleteList.rw.Lock()
+			deleteList.pk = append(deleteList.pk, rd.pk)
+			deleteList.rw.Unlock()
+		case diffValueMismatch:
+			cols := make([]string, 0, len(rd.Columns))
+			for _, c := range rd.Columns {
+				cols = append(cols, c.Name)
+			}
+			updateList.rw.Lock()
+			updateList.pk = append(updateList.pk, rd.pk)
+			updateList.cols[rd.pk.String()] = cols
+			updateList.rw.Unlock()
+		}
 	}
-	logs.Debug("DiffRowData:\n insertList:%v \n deleteList:%v \n updateList:%v", insertList.pk, deleteList.pk, updateList.pk)
-	return nil
+	diffList.Add(diffs...)
+}
+
+//resetDiffs 清空不一致的行, 开始校验新表
+func resetDiffs() {
+	insertList = NewpKList()
+	updateList = NewpKList()
+	deleteList = NewpKList()
+	diffList.Reset()
 }
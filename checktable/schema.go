@@ -0,0 +1,265 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/forest11/checktable/dbutil"
+)
+
+const (
+	schemaBlocking = "blocking" // 无法对比数据
+	schemaWarning  = "warning"  // 不影响对比数据
+)
+
+//schemaDiff 表结构的差异
+type schemaDiff struct {
+	Level  string `json:"level"`
+	Object string `json:"object"` // column/index
+	Name   string `json:"name"`
+	Item   string `json:"item"` // missing_in_dest/extra_in_dest/type/nullable/default/charset/collation/extra/order/definition
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
+}
+
+//String 输出到日志
+func (d schemaDiff) String() string {
+	return fmt.Sprintf("%s %s %s %s: source=%s dest=%s", d.Level, d.Object, d.Name, d.Item, d.Source, d.Dest)
+}
+
+//hasBlocking 是否有无法对比数据的差异
+func hasBlocking(diffs []schemaDiff) bool {
+	for _, d := range diffs {
+		if d.Level == schemaBlocking {
+			return true
+		}
+	}
+	return false
+}
+
+//DiffTableSchema 对比两边的字段和索引定义, 源表字段按映射改为目标表的字段名后对比
+// 返回对比数据的源表字段, 不包含忽略的字段, 两边按这些字段对比数据
+func DiffTableSchema(stbInfo *TableInfo, s, d *dbutil.TableStructure) ([]schemaDiff, []string) {
+	m := stbInfo.mapping
+	var diffs []schemaDiff
+	var filter map[string]bool
+	if stbInfo.filter != "" {
+		filter = make(map[string]bool)
+		for _, c := range strings.Split(stbInfo.filter, ",") {
+			c = strings.TrimSpace(c)
+			filter[c] = true
+			if _, ok := s.Column(c); !ok {
+				diffs = append(diffs, schemaDiff{Level: schemaBlocking, Object: "column", Name: c, Item: "missing_in_source"})
+			}
+		}
+	}
+
+	var cols []string
+	compared := make(map[string]bool)
+	for _, sc := range s.Columns {
+		if (filter != nil && !filter[sc.Name]) || !m.compared(sc.Name) {
+			continue
+		}
+		cols = append(cols, sc.Name)
+		// 映射为表达式的字段无法对比定义
+		if f, ok := m.destField(sc.Name); ok {
+			compared[f] = true
+		}
+	}
+
+	ms, md := m.mapSource(s), m.filterDest(d)
+	diffs = append(diffs, diffColumns(ms, md, compared)...)
+	diffs = append(diffs, diffIndexes(ms, md)...)
+	return diffs, cols
+}
+
+//diffColumns 对比字段, compared为对比数据的字段
+func diffColumns(s, d *dbutil.TableStructure, compared map[string]bool) []schemaDiff {
+	var diffs []schemaDiff
+	add := func(level, name, item, source, dest string) {
+		diffs = append(diffs, schemaDiff{Level: level, Object: "column", Name: name, Item: item, Source: source, Dest: dest})
+	}
+
+	var sOrder, dOrder []string
+	for _, sc := range s.Columns {
+		level := schemaWarning
+		if compared[sc.Name] {
+			level = schemaBlocking
+		}
+		dc, ok := d.Column(sc.Name)
+		if !ok {
+			add(level, sc.Name, "missing_in_dest", sc.ColumnType, "")
+			continue
+		}
+		sOrder = append(sOrder, sc.Name)
+
+		// 值输出的格式不同时两边的checksum都不一致, 格式相同只是警告
+		if normalizeColumnType(sc.ColumnType) != normalizeColumnType(dc.ColumnType) {
+			typeLevel := schemaWarning
+			if renderType(sc.ColumnType) != renderType(dc.ColumnType) {
+				typeLevel = level
+			}
+			add(typeLevel, sc.Name, "type", sc.ColumnType, dc.ColumnType)
+		}
+		if sc.Nullable != dc.Nullable {
+			add(schemaWarning, sc.Name, "nullable", fmt.Sprintf("%v", sc.Nullable), fmt.Sprintf("%v", dc.Nullable))
+		}
+		if normalizeDefault(sc.Default) != normalizeDefault(dc.Default) {
+			add(schemaWarning, sc.Name, "default", normalizeDefault(sc.Default), normalizeDefault(dc.Default))
+		}
+		if sc.Charset != dc.Charset {
+			add(schemaWarning, sc.Name, "charset", sc.Charset, dc.Charset)
+		}
+		if sc.Collation != dc.Collation {
+			add(schemaWarning, sc.Name, "collation", sc.Collation, dc.Collation)
+		}
+		if normalizeExtra(sc.Extra) != normalizeExtra(dc.Extra) {
+			add(schemaWarning, sc.Name, "extra", sc.Extra, dc.Extra)
+		}
+	}
+
+	for _, dc := range d.Columns {
+		if _, ok := s.Column(dc.Name); !ok {
+			add(schemaWarning, dc.Name, "extra_in_dest", "", dc.ColumnType)
+			continue
+		}
+		dOrder = append(dOrder, dc.Name)
+	}
+	if strings.Join(sOrder, ",") != strings.Join(dOrder, ",") {
+		add(schemaWarning, "", "order", strings.Join(sOrder, ","), strings.Join(dOrder, ","))
+	}
+	return diffs
+}
+
+//diffIndexes 对比索引, 索引只影响查询性能, 都是警告
+func diffIndexes(s, d *dbutil.TableStructure) []schemaDiff {
+	var diffs []schemaDiff
+	add := func(name, item, source, dest string) {
+		diffs = append(diffs, schemaDiff{Level: schemaWarning, Object: "index", Name: name, Item: item, Source: source, Dest: dest})
+	}
+	for _, si := range s.Indexes {
+		di, ok := d.Index(si.Name)
+		if !ok {
+			add(si.Name, "missing_in_dest", indexString(si), "")
+			continue
+		}
+		if indexString(si) != indexString(di) {
+			add(si.Name, "definition", indexString(si), indexString(di))
+		}
+	}
+	for _, di := range d.Indexes {
+		if _, ok := s.Index(di.Name); !ok {
+			add(di.Name, "extra_in_dest", "", indexString(di))
+		}
+	}
+	return diffs
+}
+
+//columnChanged 字段定义是否不同, 忽略版本之间显示的差异
+func columnChanged(sc, dc dbutil.ColumnInfo) bool {
+	return normalizeColumnType(sc.ColumnType) != normalizeColumnType(dc.ColumnType) ||
+		sc.Nullable != dc.Nullable ||
+		normalizeDefault(sc.Default) != normalizeDefault(dc.Default) ||
+		sc.Charset != dc.Charset ||
+		sc.Collation != dc.Collation ||
+		normalizeExtra(sc.Extra) != normalizeExtra(dc.Extra)
+}
+
+//indexString 索引定义, 例如 UNIQUE(a,b)
+func indexString(idx dbutil.IndexDef) string {
+	kind := "INDEX"
+	switch {
+	case idx.Name == "PRIMARY":
+		kind = "PRIMARY"
+	case idx.Type == "FULLTEXT" || idx.Type == "SPATIAL":
+		kind = idx.Type
+	case idx.Unique:
+		kind = "UNIQUE"
+	}
+	return fmt.Sprintf("%s(%s)", kind, strings.Join(idx.Columns, ","))
+}
+
+//mysql8和tidb不显示整型的显示宽度, int(11)与int相同; zerofill时按宽度补0, 宽度不能忽略
+var intWidth = regexp.MustCompile(`^(tinyint|smallint|mediumint|int|integer|bigint)\(\d+\)`)
+
+func normalizeColumnType(t string) string {
+	t = strings.ToLower(t)
+	if strings.Contains(t, "zerofill") {
+		return t
+	}
+	return intWidth.ReplaceAllString(t, "$1")
+}
+
+//renderType 字段的值输出为字符串的格式, 相同时两边的值可以对比, 例如int与bigint、varchar(10)与text
+//decimal的小数位数、时间的精度、unsigned、zerofill不同时格式不同
+func renderType(columnType string) string {
+	t := strings.ToLower(strings.TrimSpace(columnType))
+	base, args, attrs := t, "", ""
+	if i := strings.IndexAny(t, "( "); i >= 0 {
+		base, attrs = t[:i], t[i:]
+		if t[i] == '(' {
+			if j := strings.LastIndex(t, ")"); j > i {
+				args, attrs = t[i+1:j], t[j+1:]
+			}
+		}
+	}
+	sign := ""
+	if strings.Contains(attrs, "unsigned") {
+		sign = " unsigned"
+	}
+
+	switch base {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		if strings.Contains(attrs, "zerofill") {
+			// 按显示宽度补0
+			return fmt.Sprintf("int(%s)%s zerofill", args, sign)
+		}
+		return "int" + sign
+	case "decimal", "numeric", "dec", "fixed":
+		scale := "0"
+		if i := strings.Index(args, ","); i >= 0 {
+			scale = strings.TrimSpace(args[i+1:])
+		}
+		return fmt.Sprintf("decimal(%s)%s", scale, sign)
+	case "datetime", "timestamp", "time":
+		fsp := strings.TrimSpace(args)
+		if fsp == "" {
+			fsp = "0"
+		}
+		if base == "timestamp" {
+			base = "datetime"
+		}
+		return fmt.Sprintf("%s(%s)", base, fsp)
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext", "enum", "set":
+		return "string"
+	case "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return "bytes"
+	}
+	// binary(n)补齐长度, float和double的精度不同, 其他类型按完整的定义
+	return normalizeColumnType(t)
+}
+
+//normalizeDefault 默认值, current_timestamp()与CURRENT_TIMESTAMP相同, mariadb的字符串默认值带引号
+func normalizeDefault(d sql.NullString) string {
+	if !d.Valid {
+		return "NULL"
+	}
+	v := d.String
+	if strings.HasPrefix(strings.ToLower(v), "current_timestamp") {
+		v = strings.TrimSuffix(strings.ToLower(v), "()")
+	}
+	if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		v = v[1 : len(v)-1]
+	}
+	return v
+}
+
+//normalizeExtra mysql8默认值为表达式时有DEFAULT_GENERATED
+func normalizeExtra(e string) string {
+	e = strings.ToLower(e)
+	e = strings.Replace(e, "default_generated", "", -1)
+	e = strings.Replace(e, "()", "", -1)
+	return strings.Join(strings.Fields(e), " ")
+}
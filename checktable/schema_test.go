@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/forest11/checktable/dbutil"
+)
+
+func TestDiffColumnsTypeLevel(t *testing.T) {
+	tests := []struct {
+		sType string
+		dType string
+		want  string // 为空时没有差异
+	}{
+		{"int(11)", "int", ""},
+		{"int", "bigint", schemaWarning},
+		{"tinyint(4)", "int(11)", schemaWarning},
+		{"int", "int unsigned", schemaBlocking},
+		{"int(10) unsigned", "bigint(20) unsigned", schemaWarning},
+		{"int(5) unsigned zerofill", "int(8) unsigned zerofill", schemaBlocking},
+		{"int(5) zerofill", "int", schemaBlocking},
+		{"decimal(10,2)", "decimal(12,2)", schemaWarning},
+		{"decimal(10,2)", "decimal(12,4)", schemaBlocking},
+		{"decimal(10)", "decimal(12,0)", schemaWarning},
+		{"numeric(10,2)", "decimal(10,2)", schemaWarning},
+		{"datetime", "datetime(3)", schemaBlocking},
+		{"datetime(3)", "timestamp(3)", schemaWarning},
+		{"time(0)", "time", schemaWarning},
+		{"time", "datetime", schemaBlocking},
+		{"varchar(10)", "varchar(20)", schemaWarning},
+		{"varchar(255)", "text", schemaWarning},
+		{"enum('a','b')", "enum('a','b','c')", schemaWarning},
+		{"varchar(10)", "varbinary(10)", schemaBlocking},
+		{"blob", "longblob", schemaWarning},
+		{"binary(16)", "binary(20)", schemaBlocking},
+		{"float", "double", schemaBlocking},
+		{"int", "varchar(10)", schemaBlocking},
+	}
+	for _, tt := range tests {
+		s := &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{{Name: "c", ColumnType: tt.sType}}}
+		d := &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{{Name: "c", ColumnType: tt.dType}}}
+		diffs := diffColumns(s, d, map[string]bool{"c": true})
+		var got string
+		for _, diff := range diffs {
+			if diff.Item == "type" {
+				got = diff.Level
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s => %s: got %q, want %q", tt.sType, tt.dType, got, tt.want)
+		}
+	}
+
+	// 不对比数据的字段都是警告
+	s := &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{{Name: "c", ColumnType: "int"}}}
+	d := &dbutil.TableStructure{Columns: []dbutil.ColumnInfo{{Name: "c", ColumnType: "varchar(10)"}}}
+	if diffs := diffColumns(s, d, nil); len(diffs) != 1 || diffs[0].Level != schemaWarning {
+		t.Errorf("not compared column: got %v", diffs)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/forest11/checktable/config"
+	"github.com/forest11/checktable/dbutil"
+)
+
+//shardTable 分片合并模式下的一个源分片
+type shardTable struct {
+	label     string // 实例:端口/库.表, 报告中标识重复的行所在的分片
+	db        *sql.DB
+	dbName    string
+	tableName string
+}
+
+// 分片合并模式下的所有源分片, 为空时不合并
+var mergeShards []shardTable
+
+//initShards 连接分片实例, 按库名和表名规则找到所有分片, 返回关闭连接的函数
+func initShards() ([]shardTable, func(), error) {
+	var conns []*sql.DB
+	closeAll := func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+
+	var shards []shardTable
+	for _, info := range config.AppConf.Shards {
+		db, err := dbutil.InitDB(info.Addr, info.Port, info.User, info.Pwd, "")
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("shard %s connect err: %v", info.Name, err)
+		}
+		conns = append(conns, db)
+
+		found, err := findShardTables(db, info)
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("shard %s err: %v", info.Name, err)
+		}
+		logs.Info("shard %s %s:%s matched tables: %d", info.Name, info.Addr, info.Port, len(found))
+		shards = append(shards, found...)
+	}
+	if len(shards) == 0 {
+		closeAll()
+		return nil, nil, errors.New("no shard table matched")
+	}
+	return shards, closeAll, nil
+}
+
+//findShardTables 找到实例上库名和表名都匹配规则的表
+func findShardTables(db *sql.DB, info config.ShardInfo) ([]shardTable, error) {
+	dbs, err := dbutil.GetDatabases(db)
+	if err != nil {
+		return nil, err
+	}
+	var shards []shardTable
+	for _, dbName := range dbs {
+		ok, err := matchAny([]string{info.DBName}, dbName)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		tables, err := dbutil.GetTables(db, dbName)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tables {
+			ok, err := matchAny([]string{info.TableName}, t)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				shards = append(shards, shardTable{
+					label:     fmt.Sprintf("%s:%s/%s.%s", info.Addr, info.Port, dbName, t),
+					db:        db,
+					dbName:    dbName,
+					tableName: t,
+				})
+			}
+		}
+	}
+	return shards, nil
+}
+
+//setShards 分片合并模式, 表结构和key从第一个分片读取, 读取数据时查询所有分片
+func (t *TableInfo) setShards(shards []shardTable) {
+	t.shards = shards
+	t.dbName, t.tableName, t.db, t.snap = shards[0].dbName, shards[0].tableName, shards[0].db, nil
+}
+
+//tables 需要读取数据的表, 分片合并时为所有分片, 分片使用合并表的key、对比字段和条件
+func (t *TableInfo) tables() []*TableInfo {
+	if len(t.shards) == 0 {
+		return []*TableInfo{t}
+	}
+	tables := make([]*TableInfo, 0, len(t.shards))
+	for _, s := range t.shards {
+		st := *t
+		st.shards = nil
+		st.label, st.db, st.dbName, st.tableName = s.label, s.db, s.dbName, s.tableName
+		tables = append(tables, &st)
+	}
+	return tables
+}
+
+//diffShardSchema 对比其他分片与第一个分片的字段, 字段不同时无法合并对比
+func diffShardSchema(t *TableInfo, first *dbutil.TableStructure) ([]schemaDiff, error) {
+	var diffs []schemaDiff
+	want := shardColumns(first)
+	for _, st := range t.tables()[1:] {
+		s, err := dbutil.GetTableStructure(st.db, st.dbName, st.tableName)
+		if err != nil {
+			return nil, fmt.Errorf("%s get schema err: %v", st.label, err)
+		}
+		if got := shardColumns(s); got != want {
+			diffs = append(diffs, schemaDiff{Level: schemaBlocking, Object: "shard", Name: st.label, Item: "columns", Source: want, Dest: got})
+		}
+	}
+	return diffs, nil
+}
+
+//shardColumns 字段名和类型, 例如 id int,name varchar(10)
+func shardColumns(s *dbutil.TableStructure) string {
+	cols := make([]string, 0, len(s.Columns))
+	for _, c := range s.Columns {
+		cols = append(cols, c.Name+" "+normalizeColumnType(c.ColumnType))
+	}
+	return strings.Join(cols, ",")
+}
+
+//shardMinAndMaxPk 所有分片中最小和最大的主键, 忽略没有数据的分片
+func (t *TableInfo) shardMinAndMaxPk() (int, int, error) {
+	var min, max int
+	found := false
+	for _, st := range t.tables() {
+		sMin, sMax, err := st.minAndMaxPk()
+		if err != nil {
+			return 0, 0, err
+		}
+		if !sMin.Valid {
+			continue
+		}
+		if !found || int(sMin.Int64) < min {
+			min = int(sMin.Int64)
+		}
+		if !found || int(sMax.Int64) > max {
+			max = int(sMax.Int64)
+		}
+		found = true
+	}
+	return min, max, nil
+}
+
+//shardChecksummer 分片合并时分别计算每个分片的校验值后异或合并
+//两边的结果都带上行数, 分片之间重复的行异或后可能抵消, 行数不同时也能发现
+type shardChecksummer struct {
+	Checksummer
+}
+
+//Checksum 计算chunk的校验值, 结果为 行数:合并的校验值
+func (c shardChecksummer) Checksum(t *TableInfo, chunk chunkInfo) (string, error) {
+	var count int
+	sums := make([]string, 0, len(t.shards))
+	for _, st := range t.tables() {
+		sum, err := c.Checksummer.Checksum(st, chunk)
+		if err != nil {
+			return "", err
+		}
+		cnt, err := st.GetRangeRowCount(chunk)
+		if err != nil {
+			return "", err
+		}
+		count += cnt
+		sums = append(sums, sum)
+	}
+	sum, err := xorChecksums(sums)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%s", count, sum), nil
+}
+
+//xorChecksums 异或合并十六进制的校验值, 带行数(行数:校验值)时行数相加
+func xorChecksums(sums []string) (string, error) {
+	x, count := new(big.Int), uint64(0)
+	withCount := false
+	for _, s := range sums {
+		if i := strings.Index(s, ":"); i >= 0 {
+			var n uint64
+			if _, err := fmt.Sscanf(s[:i], "%d", &n); err != nil {
+				return "", fmt.Errorf("invalid checksum %s", s)
+			}
+			count, s, withCount = count+n, s[i+1:], true
+		}
+		if s == "" {
+			continue
+		}
+		v, ok := new(big.Int).SetString(s, 16)
+		if !ok {
+			return "", fmt.Errorf("invalid checksum %s", s)
+		}
+		x.Xor(x, v)
+	}
+	if withCount {
+		return fmt.Sprintf("%d:%s", count, x.Text(16)), nil
+	}
+	return x.Text(16), nil
+}
+
+//shardMultisetCheckSum 合并所有分片的多重集合校验值, 行数和hash的和分别相加
+func (t *TableInfo) shardMultisetCheckSum() (string, error) {
+	count, sum := new(big.Int), new(big.Int)
+	for _, st := range t.tables() {
+		s, err := st.GetMultisetCheckSum()
+		if err != nil {
+			return "", err
+		}
+		kv := strings.SplitN(s, ":", 2)
+		c, ok := new(big.Int).SetString(kv[0], 10)
+		if !ok || len(kv) != 2 {
+			return "", fmt.Errorf("%s invalid multiset checksum %s", st.label, s)
+		}
+		v, ok := new(big.Int).SetString(kv[1], 10)
+		if !ok {
+			return "", fmt.Errorf("%s invalid multiset checksum %s", st.label, s)
+		}
+		count.Add(count, c)
+		sum.Add(sum, v)
+	}
+	return fmt.Sprintf("%s:%s", count, sum), nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestXorChecksums(t *testing.T) {
+	tests := []struct {
+		name string
+		sums []string
+		want string
+		err  bool
+	}{
+		{"no shard", nil, "0", false},
+		{"single", []string{"39792cb"}, "39792cb", false},
+		{"leading zeros", []string{"055c5c6144eb1f07b47da59d6901f6c33"}, "55c5c6144eb1f07b47da59d6901f6c33", false},
+		{"xor", []string{"f0", "0f", "1"}, "fe", false},
+		{"empty chunk", []string{"0", "abc", ""}, "abc", false},
+		{"same values cancel", []string{"abc", "abc"}, "0", false},
+		{"128 bit", []string{"ffffffffffffffffffffffffffffffff", "1"}, "fffffffffffffffffffffffffffffffe", false},
+		{"with count", []string{"3:f0", "2:0f"}, "5:ff", false},
+		{"count of empty shard", []string{"0:0000000000000000", "4:00000000000000ab"}, "4:ab", false},
+		{"invalid hex", []string{"xyz"}, "", true},
+		{"invalid count", []string{"a:ff"}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := xorChecksums(tt.sums)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err %v, want err %v", tt.name, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/forest11/checktable/config"
+	"github.com/forest11/checktable/dbutil"
+)
+
+const (
+	splitSource = "source"
+	splitDest   = "dest"
+	splitBoth   = "both"
+
+	plannerAuto   = "auto"
+	plannerRegion = "region"
+	plannerStats  = "stats"
+)
+
+//chunkSplitter 分割chunk, 按主键顺序发送到chunks, 第一个chunk不限制下界, 最后一个chunk不限制上界
+type chunkSplitter interface {
+	Name() string
+	Split(ctx context.Context, chunks chan<- chunkInfo) error
+}
+
+//newSplitter 选择分割chunk的方式, chunk_planner=region时按tidb的region分割, 不支持时使用默认的方式
+//chunk_planner=stats时按直方图分割, 没有统计信息时沿key的索引分割
+//整型自增主键按值分割, 主键有较大的空洞时按值分割的chunk数超过按行数计算的2倍, 改为沿主键第一个字段的索引分割
+func newSplitter(stb, dtb *TableInfo, start, end, chunkCount int) chunkSplitter {
+	tables := splitTables(stb, dtb)
+	switch config.AppConf.ChunkPlanner {
+	case plannerRegion:
+		s, err := newRegionSplitter(stb, dtb)
+		if err == nil {
+			return s
+		}
+		logs.Warn("%s.%s can not split chunks by region: %v", stb.dbName, stb.tableName, err)
+	case plannerStats:
+		s, err := newStatsSplitter(tables, chunkCount)
+		if err == nil {
+			return s
+		}
+		logs.Warn("%s.%s can not split chunks by statistics: %v", stb.dbName, stb.tableName, err)
+		return &keysetSplitter{tables: tables, n: len(stb.pkCols)}
+	}
+	if !stb.autoPk {
+		return &keysetSplitter{tables: tables, n: len(stb.pkCols)}
+	}
+	if rangeChunks := (end-start)/chunkSize + 1; rangeChunks > 2*chunkCount {
+		logs.Info("%s.%s primary key is sparse, range chunks: %d, row chunks: %d, split by index", stb.dbName, stb.tableName, rangeChunks, chunkCount)
+		return &keysetSplitter{tables: tables, n: 1}
+	}
+	return &rangeSplitter{start: start, end: end}
+}
+
+//splitTables 按split_side选择读取chunk边界的表, 分片合并时源表没有全局的主键顺序, 只使用目标表
+func splitTables(stb, dtb *TableInfo) []*TableInfo {
+	side := config.AppConf.SplitSide
+	if len(stb.shards) > 0 && side != splitDest {
+		logs.Warn("%s.%s merge shards, split chunks by destination", dtb.dbName, dtb.tableName)
+		side = splitDest
+	}
+	switch side {
+	case splitDest:
+		return []*TableInfo{dtb}
+	case splitBoth:
+		return []*TableInfo{stb, dtb}
+	default:
+		return []*TableInfo{stb}
+	}
+}
+
+//rangeSplitter 按整型主键第一个字段的值分割, 每个chunk的范围为chunkSize, 自适应时按当前的chunk大小
+type rangeSplitter struct {
+	start int
+	end   int
+}
+
+//Name 分割方式
+func (s *rangeSplitter) Name() string { return "range" }
+
+//Split 分割chunk
+func (s *rangeSplitter) Split(ctx context.Context, chunks chan<- chunkInfo) error {
+	var lower []interface{}
+	offset := s.start - 1
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		size := nextChunkSize()
+		offset += size
+		var upper []interface{}
+		if offset < s.end {
+			upper = []interface{}{offset}
+		}
+		chunk := newChunkInfo(lower, upper)
+		chunk.size = size
+		chunks <- chunk
+		if upper == nil {
+			return nil
+		}
+		lower = upper
+	}
+}
+
+//keysetSplitter 沿主键索引从上一个边界向后读取第chunkSize行(自适应时为当前的chunk大小)作为下一个边界, 每个chunk只读取一个值
+//两边都读取时取较小的边界, 每个chunk在两边都不超过chunkSize行
+type keysetSplitter struct {
+	tables []*TableInfo
+	n      int // 使用主键的前n个字段
+}
+
+//Name 分割方式
+func (s *keysetSplitter) Name() string {
+	if len(s.tables) > 1 {
+		return "keyset(both)"
+	}
+	return fmt.Sprintf("keyset(%s.%s)", s.tables[0].dbName, s.tables[0].tableName)
+}
+
+//Split 分割chunk
+func (s *keysetSplitter) Split(ctx context.Context, chunks chan<- chunkInfo) error {
+	var lower []interface{}
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		size := nextChunkSize()
+		upper, err := s.next(lower, size)
+		if err != nil {
+			return err
+		}
+		chunk := newChunkInfo(lower, upper)
+		chunk.size = size
+		chunks <- chunk
+		if upper == nil {
+			return nil
+		}
+		lower = upper
+	}
+}
+
+//next 大于lower的第size行作为下一个边界, 两边都没有足够的行时返回nil
+func (s *keysetSplitter) next(lower []interface{}, size int) ([]interface{}, error) {
+	var upper []interface{}
+	var owner *TableInfo
+	for _, t := range s.tables {
+		q, release := t.querier()
+		b, err := dbutil.GetLimitPk(q, t.dbName, t.tableName, t.pkCols[:s.n], "", lower, nil, size)
+		release()
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			continue
+		}
+		if upper != nil {
+			// 在边界所在的表中按数据库的排序规则比较, 字符串key的排序与程序中的比较可能不同
+			q, release := owner.querier()
+			before, err := dbutil.KeyNotAfter(q, owner.dbName, owner.tableName, owner.pkCols[:s.n], upper, b)
+			release()
+			if err != nil {
+				return nil, err
+			}
+			if before {
+				continue
+			}
+		}
+		upper, owner = b, t
+	}
+	return upper, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/astaxie/beego/logs"
+	"github.com/forest11/checktable/dbutil"
+)
+
+//statsSplitter 按统计信息的直方图分割chunk, 不读取表数据, key第一个字段必须为整型
+type statsSplitter struct {
+	t      *TableInfo // 读取直方图的表
+	bounds []int64
+}
+
+//newStatsSplitter 按split_side的顺序使用第一个有直方图的表, 估算每个chunk约chunkSize行的边界
+func newStatsSplitter(tables []*TableInfo, chunkCount int) (*statsSplitter, error) {
+	var errs []string
+	for _, t := range tables {
+		buckets, err := t.histogram()
+		if err == nil && len(buckets) == 0 {
+			err = errors.New("no statistics, run ANALYZE TABLE first")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s.%s %v", t.dbName, t.tableName, err))
+			continue
+		}
+		bounds, err := histogramBounds(buckets, chunkCount)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s.%s %v", t.dbName, t.tableName, err))
+			continue
+		}
+		logs.Info("%s.%s histogram buckets: %d, chunks: %d", t.dbName, t.tableName, len(buckets), len(bounds)+1)
+		return &statsSplitter{t: t, bounds: bounds}, nil
+	}
+	return nil, errors.New(strings.Join(errs, "; "))
+}
+
+//histogram key第一个字段的直方图, tidb使用SHOW STATS_BUCKETS, mysql8使用COLUMN_STATISTICS, 单字段唯一索引上没有直方图时用EXPLAIN估算
+func (t *TableInfo) histogram() ([]dbutil.HistBucket, error) {
+	col := t.pkCols[0]
+	if col == tidbRowidCol {
+		return nil, fmt.Errorf("no statistics on %s", col)
+	}
+	colType, err := dbutil.GetColumnType(t.db, t.dbName, t.tableName, col)
+	if err != nil {
+		return nil, err
+	}
+	if !dbutil.IsIntType(colType) {
+		return nil, fmt.Errorf("key %s is %s, not int", col, colType)
+	}
+	if t.CheckDBIsTidb() {
+		return dbutil.GetTiDBStatsBuckets(t.db, t.dbName, t.tableName, col)
+	}
+	buckets, err := dbutil.GetMySQLHistogram(t.db, t.dbName, t.tableName, col)
+	if err != nil || len(buckets) > 0 {
+		return buckets, err
+	}
+	unique, err := dbutil.IsSingleColumnUnique(t.db, t.dbName, t.tableName, col)
+	if err != nil {
+		return nil, err
+	}
+	if !unique {
+		return nil, fmt.Errorf("no histogram on %s, run ANALYZE TABLE `%s`.`%s` UPDATE HISTOGRAM ON `%s` first", col, t.dbName, t.tableName, col)
+	}
+	// 单字段唯一索引(例如整型主键)上mysql8不建立直方图, 按EXPLAIN估算的行数生成
+	logs.Info("%s.%s mysql does not build histograms on %s covered by a single-column unique index, estimate buckets by EXPLAIN", t.dbName, t.tableName, col)
+	return t.estimateBuckets(col)
+}
+
+// 按EXPLAIN估算直方图时分割的段数
+const estimateBucketNum = 64
+
+//estimateBuckets 把key的最小值到最大值按值分为estimateBucketNum段, 用EXPLAIN估算每段的累计行数作为直方图
+func (t *TableInfo) estimateBuckets(col string) ([]dbutil.HistBucket, error) {
+	min, max, err := t.minAndMaxPk()
+	if err != nil || !min.Valid {
+		return nil, err
+	}
+	bounds := splitRange(min.Int64, max.Int64, estimateBucketNum)
+	buckets := make([]dbutil.HistBucket, 0, len(bounds))
+	counts := make([]int64, 0, len(bounds))
+	lower := min.Int64
+	for _, upper := range bounds {
+		cnt, err := dbutil.EstimateRangeRows(t.db, t.dbName, t.tableName, col, min.Int64, upper)
+		if err != nil {
+			return nil, err
+		}
+		// 估算值不一定单调, 累计行数取较大值
+		if len(counts) > 0 && cnt < counts[len(counts)-1] {
+			cnt = counts[len(counts)-1]
+		}
+		buckets = append(buckets, dbutil.HistBucket{Lower: strconv.FormatInt(lower, 10), Upper: strconv.FormatInt(upper, 10)})
+		counts = append(counts, cnt)
+		lower = upper + 1
+	}
+	total := counts[len(counts)-1]
+	if total == 0 {
+		return nil, nil
+	}
+	for i := range buckets {
+		buckets[i].Cum = float64(counts[i]) / float64(total)
+	}
+	return buckets, nil
+}
+
+//splitRange 把[min, max]按值均匀分为最多n段, 返回每段的上界, 最后一个为max
+//雪花算法等很大的id相减会溢出int64, 按big.Int计算
+func splitRange(min, max int64, n int) []int64 {
+	span := new(big.Int).Sub(big.NewInt(max), big.NewInt(min))
+	span.Add(span, big.NewInt(1))
+	parts := big.NewInt(int64(n))
+	if span.Cmp(parts) < 0 {
+		parts.Set(span)
+	}
+	bounds := make([]int64, 0, parts.Int64())
+	for i := int64(1); i <= parts.Int64(); i++ {
+		// min + span*i/parts - 1
+		b := new(big.Int).Mul(span, big.NewInt(i))
+		b.Quo(b, parts)
+		b.Add(b, big.NewInt(min))
+		b.Sub(b, big.NewInt(1))
+		bounds = append(bounds, b.Int64())
+	}
+	return bounds
+}
+
+//estimateChunkCount 按EXPLAIN估算的行数计算chunk数, 不读取表数据
+//估算的是整张表的行数, chunk按key的范围读取, 每个chunk扫描的行数与where条件无关
+func (t *TableInfo) estimateChunkCount() (int, error) {
+	var rows int64
+	for _, st := range t.tables() {
+		n, err := dbutil.EstimateTableRows(st.db, st.dbName, st.tableName)
+		if err != nil {
+			return 0, err
+		}
+		rows += n
+	}
+	return int((rows + int64(chunkSize) - 1) / int64(chunkSize)), nil
+}
+
+//histogramBounds 在累计比例为 1/n, 2/n ... 的位置取边界, 桶内按值线性插值
+func histogramBounds(buckets []dbutil.HistBucket, n int) ([]int64, error) {
+	var bounds []int64
+	var prev float64 // 前一个桶的累计比例
+	i := 0
+	for k := 1; k < n; k++ {
+		f := float64(k) / float64(n)
+		for i < len(buckets) && buckets[i].Cum < f {
+			prev = buckets[i].Cum
+			i++
+		}
+		if i == len(buckets) {
+			break
+		}
+		lower, err := strconv.ParseInt(buckets[i].Lower, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket bound %s", buckets[i].Lower)
+		}
+		upper, err := strconv.ParseInt(buckets[i].Upper, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket bound %s", buckets[i].Upper)
+		}
+		b := upper
+		if c := buckets[i].Cum; c > prev {
+			b = interpolate(lower, upper, (f-prev)/(c-prev))
+		}
+		if len(bounds) == 0 || b > bounds[len(bounds)-1] {
+			bounds = append(bounds, b)
+		}
+	}
+	return bounds, nil
+}
+
+//interpolate 取lower和upper之间比例为frac的值, upper-lower溢出int64时按浮点数计算
+func interpolate(lower, upper int64, frac float64) int64 {
+	if d := upper - lower; d >= 0 {
+		return lower + int64(float64(d)*frac)
+	}
+	b := float64(lower) + (float64(upper)-float64(lower))*frac
+	if b >= float64(upper) {
+		return upper
+	}
+	if b <= float64(lower) {
+		return lower
+	}
+	return int64(b)
+}
+
+//Name 分割方式
+func (s *statsSplitter) Name() string {
+	return fmt.Sprintf("stats(%s.%s)", s.t.dbName, s.t.tableName)
+}
+
+//Split 分割chunk, 统计信息过期时chunk的行数不均匀, 最后一个chunk不限制上界
+func (s *statsSplitter) Split(ctx context.Context, chunks chan<- chunkInfo) error {
+	var lower []interface{}
+	for _, b := range s.bounds {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		upper := []interface{}{b}
+		chunks <- newChunkInfo(lower, upper)
+		lower = upper
+	}
+	chunks <- newChunkInfo(lower, nil)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/forest11/checktable/dbutil"
+)
+
+func TestHistogramBounds(t *testing.T) {
+	singleton := []dbutil.HistBucket{
+		{Lower: "1", Upper: "1", Cum: 0.25},
+		{Lower: "2", Upper: "2", Cum: 0.5},
+		{Lower: "3", Upper: "3", Cum: 0.75},
+		{Lower: "4", Upper: "4", Cum: 1},
+	}
+	equiHeight := []dbutil.HistBucket{
+		{Lower: "1", Upper: "100", Cum: 0.5},
+		{Lower: "101", Upper: "200", Cum: 1},
+	}
+	tests := []struct {
+		name    string
+		buckets []dbutil.HistBucket
+		n       int
+		want    []int64
+	}{
+		{"no buckets", nil, 4, nil},
+		{"one chunk", equiHeight, 1, nil},
+		{"singleton", singleton, 4, []int64{1, 2, 3}},
+		{"equi-height", equiHeight, 4, []int64{50, 100, 150}},
+		{"more chunks than singleton buckets", singleton[2:], 8, []int64{3, 4}},
+		{"more chunks than equi-height buckets", equiHeight, 8, []int64{25, 50, 75, 100, 125, 150, 175}},
+	}
+	for _, tt := range tests {
+		got, err := histogramBounds(tt.buckets, tt.n)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := histogramBounds([]dbutil.HistBucket{{Lower: "a", Upper: "b", Cum: 1}}, 2); err == nil {
+		t.Error("non-integer bucket bound should return error")
+	}
+}
+
+func TestSplitRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int64
+		n        int
+		want     []int64
+	}{
+		{"even", 1, 100, 4, []int64{25, 50, 75, 100}},
+		{"uneven", 1, 10, 3, []int64{3, 6, 10}},
+		{"span less than n", 5, 7, 64, []int64{5, 6, 7}},
+		{"single value", 9, 9, 4, []int64{9}},
+		{"negative", -100, -1, 2, []int64{-51, -1}},
+		{"snowflake ids", 1 << 60, 1<<60 + 1<<59, 2, []int64{1<<60 + 1<<58 - 1, 1<<60 + 1<<59}},
+		{"whole int64", math.MinInt64, math.MaxInt64, 4, []int64{-1<<62 - 1, -1, 1<<62 - 1, math.MaxInt64}},
+	}
+	for _, tt := range tests {
+		got := splitRange(tt.min, tt.max, tt.n)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHistogramBoundsOverflow(t *testing.T) {
+	buckets := []dbutil.HistBucket{{Lower: "-9223372036854775808", Upper: "9223372036854775807", Cum: 1}}
+	got, err := histogramBounds(buckets, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %v, want 3 bounds", got)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] <= got[i-1] {
+			t.Errorf("bounds are not increasing: %v", got)
+		}
+	}
+	if got[1] < -1<<20 || got[1] > 1<<20 {
+		t.Errorf("middle bound %d should be near 0", got[1])
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+const (
+	statusEqual   = "equal"
+	statusDiff    = "diff"
+	statusError   = "error"
+	statusSkipped = "skipped"
+)
+
+//tableResult 单表校验结果
+type tableResult struct {
+	sTable     string
+	dTable     string
+	strategy   string // 匹配行数据使用的key
+	checksum   string // 使用的校验算法
+	status     string
+	insert     int
+	update     int
+	delete     int
+	duplicate  int // 分片之间重复的行数
+	resolved   int // 重新对比后一致的行数
+	cost       time.Duration
+	err        error
+	rows       []*rowDiff // 不一致的行
+	schema     []schemaDiff
+	chunkSizes *chunkSizeStats // 自适应时分割chunk使用的大小
+}
+
+//checkSummary 汇总所有表的校验结果
+type checkSummary struct {
+	sync.Mutex
+	results []*tableResult
+}
+
+func newCheckSummary() *checkSummary {
+	return &checkSummary{
+		results: make([]*tableResult, 0),
+	}
+}
+
+//Add 添加单表结果
+func (s *checkSummary) Add(r *tableResult) {
+	s.Lock()
+	defer s.Unlock()
+	s.results = append(s.results, r)
+}
+
+//Print 输出汇总结果到日志和标准输出
+func (s *checkSummary) Print() {
+	s.Lock()
+	defer s.Unlock()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tDESTINATION\tKEY\tCHECKSUM\tSTATUS\tINSERT\tUPDATE\tDELETE\tDUPLICATE\tRESOLVED\tCOST\tERROR")
+	total := make(map[string]int)
+	for _, r := range s.results {
+		errStr := ""
+		if r.err != nil {
+			errStr = r.err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n", r.sTable, r.dTable, r.strategy, r.checksum, r.status, r.insert, r.update, r.delete, r.duplicate, r.resolved, r.cost.Round(time.Millisecond), errStr)
+		logs.Info("summary: %s => %s key: %s checksum: %s status: %s insert: %d update: %d delete: %d duplicate: %d resolved: %d cost: %s err: %v",
+			r.sTable, r.dTable, r.strategy, r.checksum, r.status, r.insert, r.update, r.delete, r.duplicate, r.resolved, r.cost, r.err)
+		total[r.status]++
+	}
+	w.Flush()
+	s.printSchema()
+
+	line := fmt.Sprintf("tables: %d, equal: %d, diff: %d, error: %d, skipped: %d",
+		len(s.results), total[statusEqual], total[statusDiff], total[statusError], total[statusSkipped])
+	fmt.Println(line)
+	logs.Info("summary: %s", line)
+}
+
+//printSchema 输出表结构的差异
+func (s *checkSummary) printSchema() {
+	var n int
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, r := range s.results {
+		for _, d := range r.schema {
+			if n == 0 {
+				fmt.Fprintln(w, "\nSOURCE\tDESTINATION\tLEVEL\tOBJECT\tNAME\tITEM\tSOURCE_DEF\tDEST_DEF")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.sTable, r.dTable, d.Level, d.Object, d.Name, d.Item, d.Source, d.Dest)
+			n++
+		}
+	}
+	w.Flush()
+}
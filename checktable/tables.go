@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/forest11/checktable/config"
+	"github.com/forest11/checktable/dbutil"
+)
+
+//tablePair 源表和目标表
+type tablePair struct {
+	sTable string
+	dTable string
+}
+
+//matchTable 判断表名是否匹配, 以~开头为正则, 否则为glob
+func matchTable(pattern, table string) (bool, error) {
+	if strings.HasPrefix(pattern, "~") {
+		return regexp.MatchString(pattern[1:], table)
+	}
+	return path.Match(pattern, table)
+}
+
+//matchAny 表名是否匹配任意一个规则
+func matchAny(patterns []string, table string) (bool, error) {
+	for _, p := range patterns {
+		ok, err := matchTable(p, table)
+		if err != nil {
+			return false, fmt.Errorf("table pattern %s is invalid, err: %v", p, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//getTablePairs 获取需要校验的表
+func getTablePairs(sDB *sql.DB) ([]tablePair, error) {
+	conf := config.AppConf
+	if conf.Merge() {
+		// 分片合并时源表以目标表名标识
+		return []tablePair{{sTable: conf.DestDB.TableName, dTable: conf.DestDB.TableName}}, nil
+	}
+	if !conf.MultiTable() {
+		return []tablePair{{sTable: conf.SourceDB.TableName, dTable: conf.DestDB.TableName}}, nil
+	}
+
+	include := conf.IncludeTables
+	if conf.CheckAll || len(include) == 0 {
+		include = []string{"*"}
+	}
+
+	tables, err := dbutil.GetTables(sDB, conf.SourceDB.DBName)
+	if err != nil {
+		return nil, err
+	}
+
+	var pairs []tablePair
+	for _, t := range tables {
+		ok, err := matchAny(include, t)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		ok, err = matchAny(conf.ExcludeTables, t)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			continue
+		}
+		pairs = append(pairs, tablePair{sTable: t, dTable: t})
+	}
+	return pairs, nil
+}
@@ -2,18 +2,11 @@ package main
 
 import (
 	"bufio"
+	"fmt"
 	"os"
-	"os/exec"
+	"time"
 )
 
-//调用操纵系统命令
-func execShell(name string, arg ...string) (ret string, err error) {
-	cmd := exec.Command(name, arg...)
-	out, err := cmd.Output()
-	ret = string(out)
-	return
-}
-
 //写文件
 func writeFile(fileName, inStr string) error {
 	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
@@ -49,18 +42,32 @@ func getMin(x, y int) int {
 	return y
 }
 
-// reflect.DeepEqual(c1, c2) 对比struct,map
+//valueEqual 对比两个字段的值
+func valueEqual(s, d interface{}) bool {
+	if s == nil || d == nil {
+		return s == nil && d == nil
+	}
+	st, sok := s.(time.Time)
+	dt, dok := d.(time.Time)
+	if sok && dok {
+		return st.Equal(dt)
+	}
+	return fmt.Sprintf("%v", s) == fmt.Sprintf("%v", d)
+}
 
-//diffMap 获取2个map不同的数据
-func diffMap(s, d map[string]string) (sNoKey, dNoKey, diffValueKey []string) {
+//diffRowMap 获取2个map不同的数据, diffCols为值不一致的行中不同字段的下标
+func diffRowMap(s, d map[string][]interface{}) (sNoKey, dNoKey, diffValueKey []string, diffCols map[string][]int) {
+	diffCols = make(map[string][]int)
 	for sk, sv := range s {
 		dv, ok := d[sk]
-		if ok {
-			if sv != dv {
-				diffValueKey = append(diffValueKey, sk)
-			}
-		} else {
+		if !ok {
 			dNoKey = append(dNoKey, sk)
+			continue
+		}
+
+		if cols := diffValues(sv, dv); len(cols) > 0 {
+			diffValueKey = append(diffValueKey, sk)
+			diffCols[sk] = cols
 		}
 	}
 
@@ -72,3 +79,14 @@ func diffMap(s, d map[string]string) (sNoKey, dNoKey, diffValueKey []string) {
 	}
 	return
 }
+
+//diffValues 返回值不一致的字段下标
+func diffValues(sv, dv []interface{}) []int {
+	var cols []int
+	for i := range sv {
+		if i >= len(dv) || !valueEqual(sv[i], dv[i]) {
+			cols = append(cols, i)
+		}
+	}
+	return cols
+}
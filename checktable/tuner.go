@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/astaxie/beego/logs"
+)
+
+// 每次调整chunk大小的最大倍数, 避免单个chunk的耗时波动造成大小剧烈变化
+const tuneMaxRatio = 2.0
+
+// 自适应chunk大小, 为nil时使用固定的chunk_size, 每张表校验前重新创建
+var tuner *chunkTuner
+
+//chunkSizeStats 一张表分割chunk时使用的大小, 写入json报告
+type chunkSizeStats struct {
+	Initial int `json:"initial"`
+	Min     int `json:"min"`
+	Max     int `json:"max"`
+	Avg     int `json:"avg"`
+	Last    int `json:"last"`
+	Chunks  int `json:"chunks"`
+}
+
+//chunkTuner 按checksum的耗时调整之后分割的chunk大小, 使每个chunk的耗时接近目标, 大小限制在min和max之间
+type chunkTuner struct {
+	sync.Mutex
+	size   int
+	min    int
+	max    int
+	target time.Duration
+	total  int
+	stats  chunkSizeStats
+}
+
+//newChunkTuner 创建对象, 初始大小为chunk_size
+func newChunkTuner(size, min, max int, target time.Duration) *chunkTuner {
+	c := &chunkTuner{min: min, max: max, target: target}
+	c.size = c.clamp(size)
+	c.stats.Initial = c.size
+	return c
+}
+
+//clamp 限制在min和max之间
+func (c *chunkTuner) clamp(size int) int {
+	return getMin(getMax(size, c.min), c.max)
+}
+
+//next 分割下一个chunk使用的大小
+func (c *chunkTuner) next() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.size
+}
+
+//record 记录已经分割的chunk的大小
+func (c *chunkTuner) record(size int) {
+	c.Lock()
+	defer c.Unlock()
+	s := &c.stats
+	if s.Chunks == 0 || size < s.Min {
+		s.Min = size
+	}
+	if size > s.Max {
+		s.Max = size
+	}
+	s.Chunks++
+	c.total += size
+	s.Last = size
+}
+
+//observe 记录一个大小为size的chunk的checksum耗时, 按目标耗时的比例调整大小, 与当前大小取平均
+func (c *chunkTuner) observe(size int, cost time.Duration) {
+	ratio := tuneMaxRatio
+	if cost > 0 {
+		ratio = float64(c.target) / float64(cost)
+	}
+	if ratio > tuneMaxRatio {
+		ratio = tuneMaxRatio
+	} else if ratio < 1/tuneMaxRatio {
+		ratio = 1 / tuneMaxRatio
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	old := c.size
+	c.size = c.clamp((c.size + int(float64(size)*ratio)) / 2)
+	if c.size != old {
+		logs.Debug("chunk size %d cost %v, next chunk size %d => %d", size, cost, old, c.size)
+	}
+}
+
+//Stats 已经分割的chunk的大小统计, 没有分割时返回nil
+func (c *chunkTuner) Stats() *chunkSizeStats {
+	c.Lock()
+	defer c.Unlock()
+	if c.stats.Chunks == 0 {
+		return nil
+	}
+	s := c.stats
+	s.Avg = c.total / s.Chunks
+	return &s
+}
+
+//nextChunkSize 分割下一个chunk使用的大小, 没有开启自适应时为chunk_size
+func nextChunkSize() int {
+	if tuner == nil {
+		return chunkSize
+	}
+	return tuner.next()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChunkTunerObserve(t *testing.T) {
+	target := 100 * time.Millisecond
+	tests := []struct {
+		name string
+		size int
+		cost time.Duration
+		want int
+	}{
+		{"on target", 1000, target, 1000},
+		{"fast chunk grows at most 2x", 1000, 10 * time.Millisecond, 1500},
+		{"zero cost grows at most 2x", 1000, 0, 1500},
+		{"slow chunk shrinks at most 2x", 1000, time.Second, 750},
+		{"half target", 1000, 200 * time.Millisecond, 750},
+	}
+	for _, tt := range tests {
+		c := newChunkTuner(1000, 100, 4000, target)
+		c.observe(tt.size, tt.cost)
+		if got := c.next(); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChunkTunerConverge(t *testing.T) {
+	target := 100 * time.Millisecond
+	perRow := 50 * time.Microsecond // 目标耗时对应2000行
+
+	c := newChunkTuner(500, 100, 4000, target)
+	for i := 0; i < 20; i++ {
+		size := c.next()
+		c.observe(size, time.Duration(size)*perRow)
+	}
+	if got := c.next(); got < 1900 || got > 2100 {
+		t.Errorf("small initial size: got %d, want about 2000", got)
+	}
+
+	c = newChunkTuner(3900, 100, 4000, target)
+	for i := 0; i < 20; i++ {
+		size := c.next()
+		c.observe(size, time.Duration(size)*perRow)
+	}
+	if got := c.next(); got < 1900 || got > 2100 {
+		t.Errorf("large initial size: got %d, want about 2000", got)
+	}
+}
+
+func TestChunkTunerClamp(t *testing.T) {
+	target := 100 * time.Millisecond
+	c := newChunkTuner(50000, 100, 4000, target)
+	if got := c.next(); got != 4000 {
+		t.Errorf("initial size: got %d, want 4000", got)
+	}
+	for i := 0; i < 10; i++ {
+		c.observe(c.next(), time.Millisecond)
+	}
+	if got := c.next(); got != 4000 {
+		t.Errorf("fast chunks: got %d, want max 4000", got)
+	}
+	for i := 0; i < 20; i++ {
+		c.observe(c.next(), 10*time.Second)
+	}
+	if got := c.next(); got != 100 {
+		t.Errorf("slow chunks: got %d, want min 100", got)
+	}
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/astaxie/beego/config"
 )
@@ -14,63 +16,168 @@ type DBInfo struct {
 	User      string
 	Pwd       string
 	DBName    string
+	Snapshot  string // 为空不使用快照, consistent为mysql一致性快照, 其他为tidb_snapshot的tso或时间
 }
 
 //AppConfig 配置文件
 type AppConfig struct {
-	ChunkSize  int
-	ThreadsNum int
-	PkAutoInc  bool
+	ChunkSize      int
+	ThreadsNum     int
+	DiffThreadsNum int
+	BisectRows     int
+	Checksum       string
+	SplitSide      string
+	ChunkPlanner   string
+	ChunkAdaptive  bool          // 按checksum的耗时自动调整chunk大小
+	ChunkLatency   time.Duration // 自适应时每个chunk的checksum目标耗时
+	ChunkSizeMin   int
+	ChunkSizeMax   int
+	PkAutoInc      bool
 
 	FilterFiled string
 	WhereFiled  string
-	MysqlDump   string
 	DumpFile    string
+	DDLFile     string
 	Dump        bool
 
 	Level   string
 	LogPath string
 
+	ReportJSON string
+	ReportCSV  string
+
+	CheckpointFile     string
+	CheckpointInterval time.Duration
+
+	SnapshotLock     bool
+	SnapshotPoolSize int
+
+	RecheckTimes    int
+	RecheckInterval time.Duration
+
+	CheckAll      bool
+	IncludeTables []string
+	ExcludeTables []string
+
 	SourceDB DBInfo
 	DestDB   DBInfo
+
+	Shards []ShardInfo // 配置后多个源分片合并对比目标表
+}
+
+//ShardInfo 源分片所在的实例, DBName和TableName为匹配规则, 支持glob或者以~开头的正则
+type ShardInfo struct {
+	Name string
+	DBInfo
 }
 
 var AppConf AppConfig
 
+// 保留配置文件, 按表名读取[table.表名]
+var appConfig config.Configer
+
+//TableConfig 单表配置, 在[table.源表名]中配置, 没有配置时两边使用[filter]中的where
+type TableConfig struct {
+	Columns      map[string]string // 源表字段 => 目标表字段或表达式
+	SourceWhere  string
+	DestWhere    string
+	SourceIgnore []string // 不对比的源表字段
+	DestIgnore   []string // 不对比的目标表字段
+}
+
 //InitConfig 初始化配置文件
 func InitConfig(confPath string) error {
-	appConfig, err := config.NewConfig("ini", confPath)
+	var err error
+	appConfig, err = config.NewConfig("ini", confPath)
 	if err != nil {
 		return err
 	}
 
 	AppConf.ChunkSize = appConfig.DefaultInt("default::chunk_size", 500)
 	AppConf.ThreadsNum = appConfig.DefaultInt("default::threads_num", 20)
+	AppConf.DiffThreadsNum = appConfig.DefaultInt("default::diff_threads_num", 4)
+	if AppConf.DiffThreadsNum < 1 {
+		// 没有对比行数据的线程时, checksum不一致的chunk会一直等待
+		return fmt.Errorf("diff_threads_num %d is invalid, must be at least 1", AppConf.DiffThreadsNum)
+	}
+	AppConf.BisectRows = appConfig.DefaultInt("default::bisect_rows", 0)
+	AppConf.Checksum = appConfig.DefaultString("default::checksum", "auto")
+	AppConf.SplitSide = appConfig.DefaultString("default::split_side", "source")
 	AppConf.PkAutoInc = appConfig.DefaultBool("default::pk_auto_inc", true)
+	switch AppConf.SplitSide {
+	case "source", "dest", "both":
+	default:
+		return fmt.Errorf("split_side %s is invalid, use source, dest or both", AppConf.SplitSide)
+	}
+	AppConf.ChunkPlanner = appConfig.DefaultString("default::chunk_planner", "auto")
+	switch AppConf.ChunkPlanner {
+	case "auto", "region", "stats":
+	default:
+		return fmt.Errorf("chunk_planner %s is invalid, use auto, region or stats", AppConf.ChunkPlanner)
+	}
+	AppConf.ChunkAdaptive = appConfig.DefaultBool("default::chunk_adaptive", false)
+	AppConf.ChunkLatency = time.Duration(appConfig.DefaultInt("default::chunk_latency", 500)) * time.Millisecond
+	AppConf.ChunkSizeMin = appConfig.DefaultInt("default::chunk_size_min", 100)
+	AppConf.ChunkSizeMax = appConfig.DefaultInt("default::chunk_size_max", 100000)
+	if AppConf.ChunkAdaptive && (AppConf.ChunkLatency <= 0 || AppConf.ChunkSizeMin <= 0 || AppConf.ChunkSizeMin > AppConf.ChunkSizeMax) {
+		return fmt.Errorf("chunk_latency must be positive and 0 < chunk_size_min <= chunk_size_max")
+	}
 
 	AppConf.Level = appConfig.DefaultString("log::level", "debug")
 	AppConf.LogPath = appConfig.DefaultString("log::log_path", "./checktable.log")
 
+	AppConf.ReportJSON = appConfig.DefaultString("report::json_file", "")
+	AppConf.ReportCSV = appConfig.DefaultString("report::csv_file", "")
+
+	AppConf.CheckpointFile = appConfig.DefaultString("checkpoint::file", "")
+	AppConf.CheckpointInterval = time.Duration(appConfig.DefaultInt("checkpoint::save_interval", 10)) * time.Second
+
+	AppConf.SnapshotLock = appConfig.DefaultBool("snapshot::lock", true)
+	AppConf.SnapshotPoolSize = appConfig.DefaultInt("snapshot::pool_size", AppConf.ThreadsNum+AppConf.DiffThreadsNum+2)
+
+	AppConf.RecheckTimes = appConfig.DefaultInt("recheck::times", 0)
+	AppConf.RecheckInterval = time.Duration(appConfig.DefaultInt("recheck::interval", 30)) * time.Second
+
 	AppConf.Dump = appConfig.DefaultBool("dump::dump_sql", false)
-	AppConf.MysqlDump = appConfig.DefaultString("dump::mysqldump", "/usr/local/mysql/bin/mysqldump")
 	AppConf.DumpFile = appConfig.DefaultString("dump::dump_file", "./dump.sql")
+	AppConf.DDLFile = appConfig.DefaultString("dump::ddl_file", "")
 
 	AppConf.FilterFiled = appConfig.DefaultString("filter::filter_filed", "")
 	AppConf.WhereFiled = appConfig.DefaultString("filter::where", "")
 
+	AppConf.CheckAll = appConfig.DefaultBool("tables::check_all", false)
+	AppConf.IncludeTables = splitList(appConfig.DefaultString("tables::include", ""))
+	AppConf.ExcludeTables = splitList(appConfig.DefaultString("tables::exclude", ""))
+
 	AppConf.SourceDB.Addr = appConfig.DefaultString("source::addr", "127.0.0.1")
 	AppConf.SourceDB.Port = appConfig.DefaultString("source::port", "3306")
 	AppConf.SourceDB.User = appConfig.DefaultString("source::user", "mysql")
 	AppConf.SourceDB.Pwd = appConfig.DefaultString("source::password", "123")
+	AppConf.SourceDB.Snapshot = appConfig.DefaultString("source::snapshot", "")
+
+	for _, name := range splitList(appConfig.DefaultString("merge::shards", "")) {
+		section := "shard." + name
+		shard := ShardInfo{Name: name}
+		shard.Addr = appConfig.DefaultString(section+"::addr", "127.0.0.1")
+		shard.Port = appConfig.DefaultString(section+"::port", "3306")
+		shard.User = appConfig.DefaultString(section+"::user", "mysql")
+		shard.Pwd = appConfig.DefaultString(section+"::password", "123")
+		shard.DBName = appConfig.DefaultString(section+"::database", "")
+		shard.TableName = appConfig.DefaultString(section+"::table_name", "")
+		if shard.DBName == "" || shard.TableName == "" {
+			return fmt.Errorf("shard %s database or table name is null", name)
+		}
+		AppConf.Shards = append(AppConf.Shards, shard)
+	}
 
 	soureDb := appConfig.DefaultString("source::database", "")
-	if soureDb == "" {
+	if soureDb == "" && !AppConf.Merge() {
 		return fmt.Errorf("source database is null")
 	}
 	AppConf.SourceDB.DBName = soureDb
 
 	sourceTB := appConfig.DefaultString("source::table_name", "")
-	if sourceTB == "" {
+	if sourceTB == "" && !AppConf.MultiTable() && !AppConf.Merge() {
 		return fmt.Errorf("source table name is null")
 	}
 	AppConf.SourceDB.TableName = sourceTB
@@ -79,6 +186,7 @@ func InitConfig(confPath string) error {
 	AppConf.DestDB.Port = appConfig.DefaultString("destination::port", "3306")
 	AppConf.DestDB.User = appConfig.DefaultString("destination::user", "mysql")
 	AppConf.DestDB.Pwd = appConfig.DefaultString("destination::password", "123")
+	AppConf.DestDB.Snapshot = appConfig.DefaultString("destination::snapshot", "")
 
 	destDB := appConfig.DefaultString("destination::database", "")
 	if destDB == "" {
@@ -86,10 +194,56 @@ func InitConfig(confPath string) error {
 	}
 	AppConf.DestDB.DBName = destDB
 	destTb := appConfig.DefaultString("destination::table_name", "")
-	if destTb == "" {
+	if destTb == "" && !AppConf.MultiTable() {
 		return fmt.Errorf("destination table name is null")
 	}
 	AppConf.DestDB.TableName = destTb
+	if AppConf.Merge() && (destTb == "" || AppConf.MultiTable()) {
+		return fmt.Errorf("merge shards requires destination table name and can not be used with multi table")
+	}
 
 	return nil
 }
+
+//GetTableConfig 获取源表的单表配置
+// columns = id:order_id; amount:amount/100  分号分隔, 源表字段:目标表字段或表达式
+func GetTableConfig(table string) TableConfig {
+	section := "table." + table
+	c := TableConfig{
+		Columns:      make(map[string]string),
+		SourceWhere:  appConfig.DefaultString(section+"::source_where", AppConf.WhereFiled),
+		DestWhere:    appConfig.DefaultString(section+"::dest_where", AppConf.WhereFiled),
+		SourceIgnore: splitList(appConfig.DefaultString(section+"::source_ignore", "")),
+		DestIgnore:   splitList(appConfig.DefaultString(section+"::dest_ignore", "")),
+	}
+	for _, kv := range strings.Split(appConfig.DefaultString(section+"::columns", ""), ";") {
+		i := strings.Index(kv, ":")
+		if i <= 0 {
+			continue
+		}
+		c.Columns[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+	}
+	return c
+}
+
+//MultiTable 是否为多表校验模式
+func (c *AppConfig) MultiTable() bool {
+	return c.CheckAll || len(c.IncludeTables) > 0
+}
+
+//Merge 是否为分片合并校验模式, 所有分片合并后对比destination中的表
+func (c *AppConfig) Merge() bool {
+	return len(c.Shards) > 0
+}
+
+//splitList 按","分割配置项, 去掉空值
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -106,6 +106,41 @@ func ScanRowToMapStr(rows *sql.Rows) (map[string]string, error) {
 	return m, nil
 }
 
+//scanRowsByName 读取所有行, 每行为 大写列名=>值, NULL为空字符串, 缺少need中的列时返回错误
+func scanRowsByName(rows *sql.Rows, need ...string) ([]map[string]string, error) {
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	for i := range cols {
+		cols[i] = strings.ToUpper(cols[i])
+	}
+	for _, n := range need {
+		if !stringInSlice(n, cols) {
+			return nil, fmt.Errorf("result has no column %s", n)
+		}
+	}
+
+	vals := make([]sql.NullString, len(cols))
+	scans := make([]interface{}, len(cols))
+	for i := range vals {
+		scans[i] = &vals[i]
+	}
+	var result []map[string]string
+	for rows.Next() {
+		if err := rows.Scan(scans...); err != nil {
+			return nil, err
+		}
+		m := make(map[string]string, len(cols))
+		for i, c := range cols {
+			m[c] = vals[i].String
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
 //GetDBVersion 获db的版本
 func GetDBVersion(db *sql.DB) (string, error) {
 	query := "select version()"
@@ -154,3 +189,41 @@ func GetCreateTableSQL(db *sql.DB, dbName, tableName string) (string, error) {
 
 	return createTable.String, nil
 }
+
+//GetTables 获取库下所有的表(不包含视图)
+func GetTables(db *sql.DB, dbName string) ([]string, error) {
+	query := fmt.Sprintf("select TABLE_NAME from `information_schema`.`TABLES` where table_schema = \"%s\" and table_type = \"BASE TABLE\" order by TABLE_NAME", dbName)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	resultList, err := ScanRowToInterfaces(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []string
+	for _, v := range resultList {
+		tables = append(tables, string(v.([]byte)))
+	}
+	return tables, nil
+}
+
+//GetDatabases 获取实例上所有的库(不包含系统库)
+func GetDatabases(db *sql.DB) ([]string, error) {
+	query := "select SCHEMA_NAME from `information_schema`.`SCHEMATA` where SCHEMA_NAME not in (\"information_schema\", \"performance_schema\", \"mysql\", \"sys\", \"metrics_schema\") order by SCHEMA_NAME"
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	resultList, err := ScanRowToInterfaces(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbs []string
+	for _, v := range resultList {
+		dbs = append(dbs, string(v.([]byte)))
+	}
+	return dbs, nil
+}
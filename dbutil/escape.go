@@ -0,0 +1,98 @@
+package dbutil
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var intTypes = []string{"tinyint", "smallint", "mediumint", "int", "integer", "bigint"}
+
+// 驱动返回的二进制字段类型, 字符集为binary的blob返回BLOB, 其他返回TEXT
+var binaryTypes = []string{"BINARY", "VARBINARY", "TINYBLOB", "BLOB", "MEDIUMBLOB", "LONGBLOB", "BIT", "GEOMETRY"}
+
+// 驱动返回的数值字段类型, 无符号类型带UNSIGNED前缀
+var numericTypes = []string{"TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "DECIMAL", "FLOAT", "DOUBLE", "YEAR"}
+
+//IsIntType 判断字段类型是否为整型
+func IsIntType(dataType string) bool {
+	return stringInSlice(strings.ToLower(dataType), intTypes)
+}
+
+//isBinaryType 判断驱动返回的字段类型是否为二进制
+func isBinaryType(dbType string) bool {
+	return stringInSlice(strings.ToUpper(dbType), binaryTypes)
+}
+
+//IsNumericType 判断驱动返回的字段类型是否为数值
+func IsNumericType(dbType string) bool {
+	return stringInSlice(strings.TrimPrefix(strings.ToUpper(dbType), "UNSIGNED "), numericTypes)
+}
+
+//escapeString 按mysql_real_escape_string的规则转义字符串
+func escapeString(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case 0:
+			b.WriteString(`\0`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\'':
+			b.WriteString(`\'`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\x1a':
+			b.WriteString(`\Z`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+//QuoteValue 把查询出的值转换为sql字面量, 非utf8的二进制数据使用十六进制
+func QuoteValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case int:
+		return strconv.Itoa(x)
+	case uint64:
+		return strconv.FormatUint(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case bool:
+		if x {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		if x.IsZero() {
+			return "'0000-00-00 00:00:00'"
+		}
+		return x.Format("'2006-01-02 15:04:05.999999'")
+	case []byte:
+		return QuoteValue(string(x))
+	case string:
+		if !utf8.ValidString(x) {
+			return "0x" + hex.EncodeToString([]byte(x))
+		}
+		return "'" + escapeString(x) + "'"
+	default:
+		return QuoteValue(fmt.Sprintf("%v", x))
+	}
+}
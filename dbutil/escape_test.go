@@ -0,0 +1,60 @@
+package dbutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEscapeString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"abc", "abc"},
+		{`a\b`, `a\\b`},
+		{"it's", `it\'s`},
+		{`say "hi"`, `say \"hi\"`},
+		{"a\x00b", `a\0b`},
+		{"line1\nline2\r", `line1\nline2\r`},
+		{"ctrl\x1az", `ctrl\Zz`},
+		{"中文'", `中文\'`},
+		{"emoji😀\\", `emoji😀\\`},
+		{"%_", "%_"},
+	}
+	for _, tt := range tests {
+		if got := escapeString(tt.in); got != tt.want {
+			t.Errorf("escapeString(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteValue(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, "NULL"},
+		{int64(-12), "-12"},
+		{7, "7"},
+		{uint64(18446744073709551615), "18446744073709551615"},
+		{1.5, "1.5"},
+		{float32(0.1), "0.1"},
+		{true, "1"},
+		{false, "0"},
+		{"", "''"},
+		{"o'k\\", `'o\'k\\'`},
+		{"中文", "'中文'"},
+		{[]byte("a\x00b"), `'a\0b'`},
+		{[]byte{0xff, 0x00, 0x1a}, "0xff001a"},
+		{string([]byte{0xe4, 0xb8}), "0xe4b8"}, // 不完整的utf8
+		{time.Date(2021, 1, 2, 3, 4, 5, 600000000, time.UTC), "'2021-01-02 03:04:05.6'"},
+		{time.Time{}, "'0000-00-00 00:00:00'"},
+		{struct{ A int }{1}, "'{1}'"},
+	}
+	for _, tt := range tests {
+		if got := QuoteValue(tt.in); got != tt.want {
+			t.Errorf("QuoteValue(%#v) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -7,27 +7,72 @@ import (
 	"strings"
 )
 
-//GetPKName 获取主键字段名
-func GetPKName(db *sql.DB, dbName, tableName string) (string, error) {
+//GetPKColumns 获取主键字段名, 复合主键按索引中的顺序返回
+func GetPKColumns(db *sql.DB, dbName, tableName string) ([]string, error) {
 	/*
 		mysql> show index from `test`.`t2` where key_name='PRIMARY';
 		+-------+------------+----------+--------------+-------------+-----------+-------------+----------+--------+------+------------+---------+---------------+
 		| Table | Non_unique | Key_name | Seq_in_index | Column_name | Collation | Cardinality | Sub_part | Packed | Null | Index_type | Comment | Index_comment |
 		+-------+------------+----------+--------------+-------------+-----------+-------------+----------+--------+------+------------+---------+---------------+
-		| t2    |          0 | PRIMARY  |            1 | id          | A         |           0 |     NULL | NULL   |      | BTREE      |         |               |
+		| t2    |          0 | PRIMARY  |            1 | tenant_id   | A         |           0 |     NULL | NULL   |      | BTREE      |         |               |
+		| t2    |          0 | PRIMARY  |            2 | order_id    | A         |           0 |     NULL | NULL   |      | BTREE      |         |               |
 		+-------+------------+----------+--------------+-------------+-----------+-------------+----------+--------+------+------------+---------+---------------+
+		return [tenant_id order_id]
 	*/
 	query := fmt.Sprintf("show index from `%s`.`%s` where key_name='PRIMARY'", dbName, tableName)
 	rows, err := db.Query(query)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer rows.Close()
 
-	fileds, _, err := ScanRowToMap(rows)
+	cols, err := rows.Columns()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	vals := make([]sql.RawBytes, len(cols))
+	scans := make([]interface{}, len(cols))
+	for i := range vals {
+		scans[i] = &vals[i]
+	}
+
+	seqIdx, nameIdx := -1, -1
+	for i, c := range cols {
+		switch c {
+		case "Seq_in_index":
+			seqIdx = i
+		case "Column_name":
+			nameIdx = i
+		}
+	}
+	if seqIdx < 0 || nameIdx < 0 {
+		return nil, fmt.Errorf("show index from %s.%s has no Seq_in_index or Column_name", dbName, tableName)
+	}
+
+	pkMap := make(map[int]string)
+	for rows.Next() {
+		if err = rows.Scan(scans...); err != nil {
+			return nil, err
+		}
+		seq, err := strconv.Atoi(string(vals[seqIdx]))
+		if err != nil {
+			return nil, err
+		}
+		pkMap[seq] = string(vals[nameIdx])
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pkCols := make([]string, 0, len(pkMap))
+	for i := 1; i <= len(pkMap); i++ {
+		c, ok := pkMap[i]
+		if !ok {
+			return nil, fmt.Errorf("%s.%s primary key Seq_in_index %d is missing", dbName, tableName, i)
+		}
+		pkCols = append(pkCols, c)
 	}
-	return fmt.Sprintf("%s", fileds["Column_name"]), nil
+	return pkCols, nil
 }
 
 //GetTableFieldStr 以“,”拼接返回数据表中的数据
@@ -38,7 +83,7 @@ func GetTableFieldStr(db *sql.DB, dbName, tableName, filter string) (string, err
 		return filter, nil
 	}
 
-	query := fmt.Sprintf("select COLUMN_NAME from `information_schema`.`COLUMNS` where table_schema = \"%s\" and table_name = \"%s\"", dbName, tableName)
+	query := fmt.Sprintf("select COLUMN_NAME from `information_schema`.`COLUMNS` where table_schema = \"%s\" and table_name = \"%s\" order by ORDINAL_POSITION", dbName, tableName)
 	rows, err := db.Query(query)
 	if err != nil {
 		return "", err
@@ -58,49 +103,50 @@ func GetTableFieldStr(db *sql.DB, dbName, tableName, filter string) (string, err
 }
 
 
-//GetTableSchema 只获取表所有字段名称
-func GetTableSchema(db *sql.DB, dbName, tableName, filter string) (string, error) {
-	var fileds string
-	query := fmt.Sprintf("select COLUMN_NAME from `information_schema`.`COLUMNS` where table_schema = \"%s\" and table_name = \"%s\"", dbName, tableName)
-	rows, err := db.Query(query)
-	if err != nil {
-		return "", err
-	}
-	resultList, err := ScanRowToInterfaces(rows)
+//GetColumnType 获取字段的类型
+func GetColumnType(db *sql.DB, dbName, tableName, column string) (string, error) {
+	query := "select DATA_TYPE from `information_schema`.`COLUMNS` where table_schema = ? and table_name = ? and column_name = ?"
+	var dataType sql.NullString
+	err := db.QueryRow(query, dbName, tableName, column).Scan(&dataType)
 	if err != nil {
 		return "", err
 	}
-
-	for _, v := range resultList {
-		t := v.([]byte)
-		fileds += fmt.Sprintf("%s,", string(t))
-	}
-
-	return fileds, nil
+	return dataType.String, nil
 }
 
-//GetOffsetPk 根据偏移量获取主键
-func GetOffsetPk(db *sql.DB, dbName, tableName, pkName string, start, offset int) (int, error) {
-	var pk int
-
-	query := fmt.Sprintf("select %s from `%s`.`%s` where %s >= %d order by %s limit %d", pkName, dbName, tableName, pkName, start, pkName, offset)
-	rows, err := db.Query(query)
-	if err != nil {
-		return 0, err
+//GetLimitPk 获取范围(start, end]内满足filter的第offset行的主键, 只读取一行, 剩余不足offset行时返回nil
+//end为nil时不限制上界, filter为空时不过滤
+func GetLimitPk(db Querier, dbName, tableName string, pkCols []string, filter string, start, end []interface{}, offset int) ([]interface{}, error) {
+	where, args := RangeWhere(pkCols, start, end)
+	if filter != "" {
+		where = fmt.Sprintf("(%s) and %s", filter, where)
 	}
-
-	rowMap, _, err := ScanRowToMap(rows)
+	orderBy := QuoteColumns(pkCols)
+	query := fmt.Sprintf("select %s from `%s`.`%s` where %s order by %s limit %d,1", orderBy, dbName, tableName, where, orderBy, offset-1)
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	t, err := strconv.ParseInt(fmt.Sprintf("%s", rowMap[pkName]), 0, 64)
-	if err != nil {
-		return 0, err
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
 	}
-	pk = int(t)
-	return pk, nil
+	return ScanRowValues(rows, len(pkCols))
 }
 
+//KeyNotAfter 按表中key的排序规则判断a是否不大于b, a必须是表中存在的key
+func KeyNotAfter(db Querier, dbName, tableName string, pkCols []string, a, b []interface{}) (bool, error) {
+	eq, args := KeyEqualArgs(pkCols, a)
+	where, bArgs := RangeWhere(pkCols, nil, b)
+	args = append(args, bArgs...)
+	query := fmt.Sprintf("select count(*) from `%s`.`%s` where %s and %s", dbName, tableName, eq, where)
+	var cnt int
+	if err := db.QueryRow(query, args...).Scan(&cnt); err != nil {
+		return false, err
+	}
+	return cnt > 0, nil
+}
 
 // GetTableFieldAndType 返回数据和类型
 func GetTableFieldAndType(db *sql.DB, dbName, tableName, filter string) ([]string, error) {
@@ -133,8 +179,13 @@ func GetTableFieldAndType(db *sql.DB, dbName, tableName, filter string) ([]strin
 			filedList = append(filedList, fmt.Sprintf("%s#%s", f, resultMap[f]))
 		}
 	} else {
-		for k, v := range resultMap {
-			filedList = append(filedList, fmt.Sprintf("%s#%s", k, v))
+		// map是无序的, 按字段顺序拼接, 保证两边生成的表达式一致
+		colsStr, err := GetTableFieldStr(db, dbName, tableName, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range strings.Split(colsStr, ",") {
+			filedList = append(filedList, fmt.Sprintf("%s#%s", k, resultMap[k]))
 		}
 	}
 
@@ -142,15 +193,22 @@ func GetTableFieldAndType(db *sql.DB, dbName, tableName, filter string) ([]strin
 }
 
 
-// FormatCrc 格式化成crc字符串
-func FormatCrc(filedList []string) string {
+// FormatRowMd5 格式化成单行数据的md5表达式
+func FormatRowMd5(filedList []string) string {
+	return fmt.Sprintf("md5(%s)", formatRowConcat(filedList))
+}
+
+// formatRowConcat 单行数据拼接为字符串, 最后拼接每个字段是否为NULL
+func formatRowConcat(filedList []string) string {
 	var concatWs []string
 	var concatIsnull []string
 
 	var strType = []string{"char", "varchar"}
 	var bigType = []string{"tinyblob", "tinytext", "blob", "text", "mediumblob", "mediumtext", "longblob", "longtext"}
 	for _, filed := range filedList {
-		cln := strings.Split(filed, "#")
+		// 表达式中可能有#, 按最后一个#分割
+		i := strings.LastIndex(filed, "#")
+		cln := []string{filed[:i], filed[i+1:]}
 		concatIsnull = append(concatIsnull, fmt.Sprintf("ISNULL(%s)", cln[0]))
 		if stringInSlice(cln[1], strType) {  // 如果是char、varchar类型，转换为utf8mb4
 			concatWs = append(concatWs, fmt.Sprintf("CONVERT(%s using utf8mb4)", cln[0]))
@@ -160,11 +218,43 @@ func FormatCrc(filedList []string) string {
 			concatWs = append(concatWs, cln[0])
 		}
 	}
-	// 生成crc的核心校验语句
-	f := fmt.Sprintf("COALESCE(LOWER(CONCAT(LPAD(CONV(BIT_XOR(CAST(CONV(SUBSTRING(@crc, 1, 16), 16, 10) AS UNSIGNED)), " +
-			"10, 16), 16, '0'), LPAD(CONV(BIT_XOR(CAST(CONV(SUBSTRING(@crc := md5(CONCAT_WS('#', %s, CONCAT(%s))), " + 
-			"17, 16), 16, 10) AS UNSIGNED)), 10, 16), 16, '0'))),0) AS checksum", strings.Join(concatWs, ","), strings.Join(concatIsnull, ","))
-	return f
+	return fmt.Sprintf("CONCAT_WS('#', %s, CONCAT(%s))", strings.Join(concatWs, ","), strings.Join(concatIsnull, ","))
+}
+
+// FormatRowCrc32 格式化成单行数据的crc32表达式
+// CONCAT_WS会跳过NULL, (1,NULL,'a')和(1,'a',NULL)都拼接为1#a, 最后拼接每个字段是否为NULL区分NULL的位置
+func FormatRowCrc32(cols []string) string {
+	var concatIsnull []string
+	for _, c := range cols {
+		concatIsnull = append(concatIsnull, fmt.Sprintf("ISNULL(%s)", c))
+	}
+	return fmt.Sprintf("CRC32(CONCAT_WS('#',%s, CONCAT(%s)))", strings.Join(cols, ","), strings.Join(concatIsnull, ","))
+}
+
+// FormatCrc 格式化成md5校验语句, md5分为两段16进制分别BIT_XOR
+func FormatCrc(filedList []string) string {
+	return formatXorHash(FormatRowMd5(filedList), 2)
+}
+
+// FormatSha2 格式化成sha2校验语句, 取sha256的前128位分为两段BIT_XOR
+func FormatSha2(filedList []string) string {
+	return formatXorHash(fmt.Sprintf("SHA2(%s, 256)", formatRowConcat(filedList)), 2)
+}
+
+// formatXorHash 16进制的行hash每16个字符一段, 每段转换为整数后BIT_XOR, 与行的顺序无关
+// 每段重复计算行hash, 不使用用户变量, 避免结果依赖连接上次查询留下的变量
+func formatXorHash(rowHash string, segments int) string {
+	var parts []string
+	for i := 0; i < segments; i++ {
+		parts = append(parts, fmt.Sprintf("LPAD(CONV(BIT_XOR(CAST(CONV(SUBSTRING(%s, %d, 16), 16, 10) AS UNSIGNED)), 10, 16), 16, '0')", rowHash, i*16+1))
+	}
+	return fmt.Sprintf("COALESCE(LOWER(CONCAT(%s)),0) AS checksum", strings.Join(parts, ", "))
+}
+
+// FormatMultiset 格式化成与顺序无关的多重集合校验, 重复行不会互相抵消
+func FormatMultiset(filedList []string) string {
+	return fmt.Sprintf("CONCAT(COUNT(*), ':', COALESCE(SUM(CAST(CONV(SUBSTRING(%s, 1, 16), 16, 10) AS UNSIGNED)), 0)) AS checksum",
+		FormatRowMd5(filedList))
 }
 
 // stringInSlice 遍历数组
@@ -175,4 +265,53 @@ func stringInSlice(a string, list []string) bool {
         }
     }
     return false
-}
\ No newline at end of file
+}
+
+//IndexInfo 索引信息
+type IndexInfo struct {
+	Name    string
+	Columns []string
+	NotNull bool // 所有字段都是NOT NULL
+	Partial bool // 包含前缀索引或者表达式索引
+}
+
+//GetUniqueKeys 获取主键以外的唯一索引
+func GetUniqueKeys(db *sql.DB, dbName, tableName string) ([]IndexInfo, error) {
+	/*
+		+------------+-------------+----------+-------------+
+		| INDEX_NAME | COLUMN_NAME | SUB_PART | IS_NULLABLE |
+		+------------+-------------+----------+-------------+
+		| uk_order   | tenant_id   |     NULL | NO          |
+		| uk_order   | order_no    |     NULL | NO          |
+		+------------+-------------+----------+-------------+
+	*/
+	query := "select s.INDEX_NAME, s.COLUMN_NAME, s.SUB_PART, c.IS_NULLABLE from `information_schema`.`STATISTICS` s " +
+		"left join `information_schema`.`COLUMNS` c on c.TABLE_SCHEMA = s.TABLE_SCHEMA and c.TABLE_NAME = s.TABLE_NAME and c.COLUMN_NAME = s.COLUMN_NAME " +
+		"where s.TABLE_SCHEMA = ? and s.TABLE_NAME = ? and s.NON_UNIQUE = 0 and s.INDEX_NAME <> 'PRIMARY' order by s.INDEX_NAME, s.SEQ_IN_INDEX"
+	rows, err := db.Query(query, dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []IndexInfo
+	for rows.Next() {
+		var name, column, subPart, nullable sql.NullString
+		if err = rows.Scan(&name, &column, &subPart, &nullable); err != nil {
+			return nil, err
+		}
+		if len(indexes) == 0 || indexes[len(indexes)-1].Name != name.String {
+			indexes = append(indexes, IndexInfo{Name: name.String, NotNull: true})
+		}
+		idx := &indexes[len(indexes)-1]
+		if !column.Valid || subPart.Valid {
+			idx.Partial = true
+			continue
+		}
+		idx.Columns = append(idx.Columns, column.String)
+		if nullable.String != "NO" {
+			idx.NotNull = false
+		}
+	}
+	return indexes, rows.Err()
+}
@@ -0,0 +1,202 @@
+package dbutil
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+// exprEval 按mysql的规则计算校验生成的行表达式, 只支持用到的函数, NULL为nil
+type exprEval struct {
+	s   string
+	pos int
+	row map[string]interface{}
+}
+
+func evalRowExpr(expr string, row map[string]interface{}) (interface{}, error) {
+	e := &exprEval{s: expr, row: row}
+	v, err := e.expr()
+	if err != nil {
+		return nil, err
+	}
+	if e.skipSpace(); e.pos != len(e.s) {
+		return nil, fmt.Errorf("unexpected %q at %d", e.s[e.pos:], e.pos)
+	}
+	return v, nil
+}
+
+func (e *exprEval) skipSpace() {
+	for e.pos < len(e.s) && e.s[e.pos] == ' ' {
+		e.pos++
+	}
+}
+
+func (e *exprEval) expect(c byte) error {
+	if e.skipSpace(); e.pos >= len(e.s) || e.s[e.pos] != c {
+		return fmt.Errorf("expect %q at %d in %s", c, e.pos, e.s)
+	}
+	e.pos++
+	return nil
+}
+
+func (e *exprEval) expr() (interface{}, error) {
+	e.skipSpace()
+	if e.pos >= len(e.s) {
+		return nil, fmt.Errorf("unexpected end of %s", e.s)
+	}
+	switch c := e.s[e.pos]; {
+	case c == '`':
+		end := strings.IndexByte(e.s[e.pos+1:], '`')
+		name := e.s[e.pos+1 : e.pos+1+end]
+		e.pos += end + 2
+		v, ok := e.row[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %s", name)
+		}
+		return v, nil
+	case c == '\'':
+		end := strings.IndexByte(e.s[e.pos+1:], '\'')
+		v := e.s[e.pos+1 : e.pos+1+end]
+		e.pos += end + 2
+		return v, nil
+	}
+
+	start := e.pos
+	for e.pos < len(e.s) && (e.s[e.pos] == '_' || e.s[e.pos] >= '0' && e.s[e.pos] <= '9' || e.s[e.pos] >= 'A' && e.s[e.pos] <= 'z') {
+		e.pos++
+	}
+	name := strings.ToUpper(e.s[start:e.pos])
+	if err := e.expect('('); err != nil {
+		return nil, err
+	}
+	var args []interface{}
+	for {
+		v, err := e.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+		if e.skipSpace(); name == "CONVERT" && strings.HasPrefix(e.s[e.pos:], "using utf8mb4") {
+			e.pos += len("using utf8mb4")
+		}
+		if e.skipSpace(); e.pos < len(e.s) && e.s[e.pos] == ',' {
+			e.pos++
+			continue
+		}
+		if err := e.expect(')'); err != nil {
+			return nil, err
+		}
+		break
+	}
+	return callFunc(name, args)
+}
+
+func callFunc(name string, args []interface{}) (interface{}, error) {
+	switch name {
+	case "CONCAT_WS":
+		// 跳过NULL
+		var parts []string
+		for _, a := range args[1:] {
+			if a != nil {
+				parts = append(parts, a.(string))
+			}
+		}
+		return strings.Join(parts, args[0].(string)), nil
+	case "CONCAT":
+		var b strings.Builder
+		for _, a := range args {
+			if a == nil {
+				return nil, nil
+			}
+			b.WriteString(a.(string))
+		}
+		return b.String(), nil
+	case "ISNULL":
+		if args[0] == nil {
+			return "1", nil
+		}
+		return "0", nil
+	case "CONVERT":
+		return args[0], nil
+	case "CRC32":
+		if args[0] == nil {
+			return nil, nil
+		}
+		return fmt.Sprintf("%d", crc32.ChecksumIEEE([]byte(args[0].(string)))), nil
+	case "MD5":
+		if args[0] == nil {
+			return nil, nil
+		}
+		sum := md5.Sum([]byte(args[0].(string)))
+		return hex.EncodeToString(sum[:]), nil
+	}
+	return nil, fmt.Errorf("unsupported function %s", name)
+}
+
+func TestRowHashNull(t *testing.T) {
+	cols := []string{"`id`", "`a`", "`b`"}
+	fields := []string{"`id`#int", "`a`#varchar", "`b`#varchar"}
+	exprs := map[string]string{
+		"crc32": FormatRowCrc32(cols),
+		"md5":   FormatRowMd5(fields),
+		"sha2":  formatRowConcat(fields),
+	}
+	// 每组的两行值不同, 行表达式不能相同
+	pairs := []struct {
+		name string
+		r1   []interface{}
+		r2   []interface{}
+	}{
+		{"null position", []interface{}{"1", nil, "a"}, []interface{}{"1", "a", nil}},
+		{"null vs 'null'", []interface{}{"1", nil, "x"}, []interface{}{"1", "null", "x"}},
+		{"null vs empty string", []interface{}{"1", nil, "x"}, []interface{}{"1", "", "x"}},
+		{"all null vs empty strings", []interface{}{"1", nil, nil}, []interface{}{"1", "", ""}},
+		{"separator in value", []interface{}{"1", "a#b", nil}, []interface{}{"1", "a", "b"}},
+	}
+	row := func(vals []interface{}) map[string]interface{} {
+		return map[string]interface{}{"id": vals[0], "a": vals[1], "b": vals[2]}
+	}
+	for name, expr := range exprs {
+		for _, p := range pairs {
+			v1, err := evalRowExpr(expr, row(p.r1))
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			v2, err := evalRowExpr(expr, row(p.r2))
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if v1 == nil || v2 == nil {
+				t.Errorf("%s %s: row expression is NULL: %v %v", name, p.name, v1, v2)
+			}
+			if v1 == v2 {
+				t.Errorf("%s %s: %v and %v both give %v", name, p.name, p.r1, p.r2, v1)
+			}
+		}
+	}
+}
+
+func TestFormatRowConcat(t *testing.T) {
+	tests := []struct {
+		fields []string
+		want   string
+	}{
+		{
+			fields: []string{"`id`#int", "`a`#varchar", "`b`#varchar"},
+			want:   "CONCAT_WS('#', `id`,CONVERT(`a` using utf8mb4),CONVERT(`b` using utf8mb4), CONCAT(ISNULL(`id`),ISNULL(`a`),ISNULL(`b`)))",
+		},
+		{
+			// 表达式中有#时按最后一个#分割
+			fields: []string{"`id`#int", "CONCAT(`a`,'#')#varchar", "`b`#text"},
+			want:   "CONCAT_WS('#', `id`,CONVERT(CONCAT(`a`,'#') using utf8mb4),CRC32(`b`), CONCAT(ISNULL(`id`),ISNULL(CONCAT(`a`,'#')),ISNULL(`b`)))",
+		},
+	}
+	for _, tt := range tests {
+		if got := formatRowConcat(tt.fields); got != tt.want {
+			t.Errorf("formatRowConcat(%v) = %s, want %s", tt.fields, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package dbutil
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//TableRegion tidb表的region, key为SHOW TABLE REGIONS显示的格式, 例如 t_75_r_2000
+type TableRegion struct {
+	ID       int64
+	StartKey string
+	EndKey   string
+	Keys     int64 // 估算的key数量
+}
+
+//GetTableRegions 获取tidb表的所有region, 包括索引所在的region
+func GetTableRegions(db *sql.DB, dbName, tableName string) ([]TableRegion, error) {
+	/*
+		tidb> show table `test`.`t1` regions;
+		+-----------+-------------+-------------+-----------+-----------------+-------+------------+---------------+------------+----------------------+------------------+
+		| REGION_ID | START_KEY   | END_KEY     | LEADER_ID | LEADER_STORE_ID | PEERS | SCATTERING | WRITTEN_BYTES | READ_BYTES | APPROXIMATE_SIZE(MB) | APPROXIMATE_KEYS |
+		+-----------+-------------+-------------+-----------+-----------------+-------+------------+---------------+------------+----------------------+------------------+
+		|       102 | t_75_       | t_75_r_2000 |       103 |               1 | 103   |          0 |             0 |          0 |                    1 |             1999 |
+		|         2 | t_75_r_2000 | t_76_       |         3 |               1 | 3     |          0 |             0 |          0 |                    1 |             8001 |
+		+-----------+-------------+-------------+-----------+-----------------+-------+------------+---------------+------------+----------------------+------------------+
+	*/
+	query := fmt.Sprintf("show table `%s`.`%s` regions", dbName, tableName)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	// 不同版本的列数不同, 按列名读取
+	result, err := scanRowsByName(rows, "REGION_ID", "START_KEY", "END_KEY", "APPROXIMATE_KEYS")
+	if err != nil {
+		return nil, err
+	}
+
+	regions := make([]TableRegion, 0, len(result))
+	for _, m := range result {
+		r := TableRegion{StartKey: m["START_KEY"], EndKey: m["END_KEY"]}
+		r.ID, _ = strconv.ParseInt(m["REGION_ID"], 10, 64)
+		r.Keys, _ = strconv.ParseInt(m["APPROXIMATE_KEYS"], 10, 64)
+		regions = append(regions, r)
+	}
+	return regions, nil
+}
+
+//GetTiDBTableIDs 获取tidb表数据所在的id, 分区表为所有分区的id
+func GetTiDBTableIDs(db *sql.DB, dbName, tableName string) ([]int64, error) {
+	query := "select TIDB_PARTITION_ID from `information_schema`.`PARTITIONS` where table_schema = ? and table_name = ? and TIDB_PARTITION_ID is not null"
+	rows, err := db.Query(query, dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		return ids, nil
+	}
+
+	var id int64
+	query = "select TIDB_TABLE_ID from `information_schema`.`TABLES` where table_schema = ? and table_name = ?"
+	if err := db.QueryRow(query, dbName, tableName).Scan(&id); err != nil {
+		return nil, err
+	}
+	return []int64{id}, nil
+}
+
+//IsTiDBClustered 判断tidb表的主键是否为聚簇索引, 聚簇的整型主键即为行数据key中的handle
+func IsTiDBClustered(db *sql.DB, dbName, tableName string) (bool, error) {
+	query := "select TIDB_PK_TYPE from `information_schema`.`TABLES` where table_schema = ? and table_name = ?"
+	var pkType sql.NullString
+	if err := db.QueryRow(query, dbName, tableName).Scan(&pkType); err != nil {
+		return false, err
+	}
+	return pkType.String == "CLUSTERED", nil
+}
+
+// region key在表id的行数据中的位置
+const (
+	KeyBeforeRecords = -1 // 在行数据之前, 例如 t_75_、t_75_i_1_xxx、前一个表的key
+	KeyInRecords     = 0  // 行数据的key, handle为整型
+	KeyAfterRecords  = 1  // 在行数据之后, 例如 t_76_
+)
+
+//DecodeRecordKey 解析region key相对于表id行数据的位置, 行数据的key返回整型handle
+//isEnd为true时空key表示最后, 否则表示最前; 非整型handle(非整型的聚簇主键)返回错误
+func DecodeRecordKey(key string, tableID int64, isEnd bool) (int, int64, error) {
+	if key == "" {
+		if isEnd {
+			return KeyAfterRecords, 0, nil
+		}
+		return KeyBeforeRecords, 0, nil
+	}
+	if !strings.HasPrefix(key, "t_") {
+		// 表数据之外的key, 例如 m 开头的元数据
+		if key < "t_" {
+			return KeyBeforeRecords, 0, nil
+		}
+		return KeyAfterRecords, 0, nil
+	}
+
+	parts := strings.SplitN(key[2:], "_", 3)
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid region key %s", key)
+	}
+	switch {
+	case id < tableID:
+		return KeyBeforeRecords, 0, nil
+	case id > tableID:
+		return KeyAfterRecords, 0, nil
+	}
+	// 同一个表中索引(i)在行数据(r)之前
+	if len(parts) < 2 || parts[1] < "r" {
+		return KeyBeforeRecords, 0, nil
+	}
+	if parts[1] > "r" {
+		return KeyAfterRecords, 0, nil
+	}
+	if len(parts) < 3 || parts[2] == "" {
+		return KeyBeforeRecords, 0, nil
+	}
+	handle, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("region key %s is not an int handle", key)
+	}
+	return KeyInRecords, handle, nil
+}
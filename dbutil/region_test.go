@@ -0,0 +1,45 @@
+package dbutil
+
+import "testing"
+
+func TestDecodeRecordKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		isEnd   bool
+		pos     int
+		handle  int64
+		wantErr bool
+	}{
+		{key: "", isEnd: false, pos: KeyBeforeRecords},
+		{key: "", isEnd: true, pos: KeyAfterRecords},
+		{key: "t_75_", pos: KeyBeforeRecords},
+		{key: "t_75_i_1_0380000000000001", pos: KeyBeforeRecords},
+		{key: "t_75_r_", pos: KeyBeforeRecords},
+		{key: "t_75_r", pos: KeyBeforeRecords},
+		{key: "t_75_r_2000", pos: KeyInRecords, handle: 2000},
+		{key: "t_75_r_-5", pos: KeyInRecords, handle: -5},
+		{key: "t_74_r_100", pos: KeyBeforeRecords},
+		{key: "t_76_", isEnd: true, pos: KeyAfterRecords},
+		{key: "t_76_r_1", pos: KeyAfterRecords},
+		{key: "m_ddl", pos: KeyBeforeRecords},
+		{key: "z", isEnd: true, pos: KeyAfterRecords},
+		{key: "t_75_r_03800000000000000a", wantErr: true},
+		{key: "t_x_r_1", wantErr: true},
+	}
+	for _, tt := range tests {
+		pos, handle, err := DecodeRecordKey(tt.key, 75, tt.isEnd)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("DecodeRecordKey(%q) expect error", tt.key)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("DecodeRecordKey(%q) err: %v", tt.key, err)
+			continue
+		}
+		if pos != tt.pos || (pos == KeyInRecords && handle != tt.handle) {
+			t.Errorf("DecodeRecordKey(%q, end=%v) = %d, %d, want %d, %d", tt.key, tt.isEnd, pos, handle, tt.pos, tt.handle)
+		}
+	}
+}
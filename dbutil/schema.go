@@ -0,0 +1,125 @@
+package dbutil
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+//ColumnInfo 字段定义
+type ColumnInfo struct {
+	Name       string
+	Position   int
+	DataType   string // int
+	ColumnType string // int(11) unsigned
+	Nullable   bool
+	Default    sql.NullString
+	Charset    string
+	Collation  string
+	Extra      string
+}
+
+//IndexDef 索引定义
+type IndexDef struct {
+	Name    string
+	Unique  bool
+	Type    string   // BTREE/HASH/FULLTEXT/SPATIAL
+	Columns []string // 前缀索引为 name(10), 表达式索引为 (expression)
+}
+
+//TableStructure 表结构
+type TableStructure struct {
+	Columns []ColumnInfo
+	Indexes []IndexDef
+}
+
+//Column 按名称查找字段
+func (t *TableStructure) Column(name string) (ColumnInfo, bool) {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return ColumnInfo{}, false
+}
+
+//Index 按名称查找索引
+func (t *TableStructure) Index(name string) (IndexDef, bool) {
+	for _, idx := range t.Indexes {
+		if idx.Name == name {
+			return idx, true
+		}
+	}
+	return IndexDef{}, false
+}
+
+//GetTableStructure 获取表的字段和索引定义
+func GetTableStructure(db *sql.DB, dbName, tableName string) (*TableStructure, error) {
+	cols, err := GetTableColumns(db, dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := GetTableIndexes(db, dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return &TableStructure{Columns: cols, Indexes: indexes}, nil
+}
+
+//GetTableColumns 按字段顺序获取字段定义
+func GetTableColumns(db *sql.DB, dbName, tableName string) ([]ColumnInfo, error) {
+	query := "select COLUMN_NAME, ORDINAL_POSITION, DATA_TYPE, COLUMN_TYPE, IS_NULLABLE, COLUMN_DEFAULT, CHARACTER_SET_NAME, COLLATION_NAME, EXTRA " +
+		"from `information_schema`.`COLUMNS` where TABLE_SCHEMA = ? and TABLE_NAME = ? order by ORDINAL_POSITION"
+	rows, err := db.Query(query, dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []ColumnInfo
+	for rows.Next() {
+		var c ColumnInfo
+		var nullable string
+		var charset, collation, extra sql.NullString
+		if err = rows.Scan(&c.Name, &c.Position, &c.DataType, &c.ColumnType, &nullable, &c.Default, &charset, &collation, &extra); err != nil {
+			return nil, err
+		}
+		c.Nullable = nullable == "YES"
+		c.Charset, c.Collation, c.Extra = charset.String, collation.String, extra.String
+		cols = append(cols, c)
+	}
+	return cols, rows.Err()
+}
+
+//GetTableIndexes 获取所有索引, 包括主键
+func GetTableIndexes(db *sql.DB, dbName, tableName string) ([]IndexDef, error) {
+	query := "select INDEX_NAME, NON_UNIQUE, INDEX_TYPE, COLUMN_NAME, SUB_PART from `information_schema`.`STATISTICS` " +
+		"where TABLE_SCHEMA = ? and TABLE_NAME = ? order by INDEX_NAME, SEQ_IN_INDEX"
+	rows, err := db.Query(query, dbName, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []IndexDef
+	for rows.Next() {
+		var name string
+		var nonUnique int
+		var indexType, column, subPart sql.NullString
+		if err = rows.Scan(&name, &nonUnique, &indexType, &column, &subPart); err != nil {
+			return nil, err
+		}
+		if len(indexes) == 0 || indexes[len(indexes)-1].Name != name {
+			indexes = append(indexes, IndexDef{Name: name, Unique: nonUnique == 0, Type: indexType.String})
+		}
+		idx := &indexes[len(indexes)-1]
+		switch {
+		case !column.Valid:
+			idx.Columns = append(idx.Columns, "(expression)")
+		case subPart.Valid:
+			idx.Columns = append(idx.Columns, fmt.Sprintf("%s(%s)", column.String, subPart.String))
+		default:
+			idx.Columns = append(idx.Columns, column.String)
+		}
+	}
+	return indexes, rows.Err()
+}
@@ -0,0 +1,123 @@
+package dbutil
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+//Querier 查询数据的接口, *sql.DB和SnapshotConn都实现了该接口
+type Querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+//SnapshotConn 固定在某个快照上的连接
+type SnapshotConn struct {
+	conn *sql.Conn
+}
+
+//Query 在快照上查询
+func (c *SnapshotConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(context.Background(), query, args...)
+}
+
+//QueryRow 在快照上查询一行
+func (c *SnapshotConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(context.Background(), query, args...)
+}
+
+//SnapshotPool 快照连接池, 池中所有连接读取同一时间点的数据
+type SnapshotPool struct {
+	conns chan *SnapshotConn
+	all   []*SnapshotConn
+	reset string // 连接放回*sql.DB之前清除快照
+}
+
+//NewTiDBSnapshotPool 创建tidb快照连接池, snapshot为tso或者时间, 例如 415278947461939201 或 2021-01-01 00:00:00
+func NewTiDBSnapshotPool(ctx context.Context, db *sql.DB, size int, snapshot string) (*SnapshotPool, error) {
+	p := newSnapshotPool(size, "SET @@tidb_snapshot = ''")
+	for i := 0; i < size; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.add(conn)
+		if _, err = conn.ExecContext(ctx, "SET @@tidb_snapshot = ?", snapshot); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("set tidb_snapshot %s err: %v", snapshot, err)
+		}
+	}
+	return p, nil
+}
+
+//NewMySQLSnapshotPool 创建mysql一致性快照连接池, lock为true时在FLUSH TABLES WITH READ LOCK下开启事务, 保证所有连接的快照相同
+//不加锁时每个连接开启快照的时间不同, 只允许一个连接
+func NewMySQLSnapshotPool(ctx context.Context, db *sql.DB, size int, lock bool) (*SnapshotPool, error) {
+	if !lock && size > 1 {
+		return nil, fmt.Errorf("consistent snapshot with %d connections requires lock=true, or set pool_size=1", size)
+	}
+	if lock {
+		lockConn, err := db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer lockConn.Close()
+		if _, err = lockConn.ExecContext(ctx, "FLUSH TABLES WITH READ LOCK"); err != nil {
+			return nil, fmt.Errorf("flush tables with read lock err: %v", err)
+		}
+		defer lockConn.ExecContext(ctx, "UNLOCK TABLES")
+	}
+
+	p := newSnapshotPool(size, "ROLLBACK")
+	for i := 0; i < size; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.add(conn)
+		if _, err = conn.ExecContext(ctx, "SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ"); err != nil {
+			p.Close()
+			return nil, err
+		}
+		if _, err = conn.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT"); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("start transaction with consistent snapshot err: %v", err)
+		}
+	}
+	return p, nil
+}
+
+func newSnapshotPool(size int, reset string) *SnapshotPool {
+	return &SnapshotPool{
+		conns: make(chan *SnapshotConn, size),
+		all:   make([]*SnapshotConn, 0, size),
+		reset: reset,
+	}
+}
+
+func (p *SnapshotPool) add(conn *sql.Conn) {
+	c := &SnapshotConn{conn: conn}
+	p.all = append(p.all, c)
+	p.conns <- c
+}
+
+//Get 获取连接, 没有空闲连接时等待
+func (p *SnapshotPool) Get() *SnapshotConn {
+	return <-p.conns
+}
+
+//Put 归还连接, 归还前必须关闭查询返回的rows
+func (p *SnapshotPool) Put(c *SnapshotConn) {
+	p.conns <- c
+}
+
+//Close 清除快照后把连接还给*sql.DB
+func (p *SnapshotPool) Close() {
+	for _, c := range p.all {
+		c.conn.ExecContext(context.Background(), p.reset)
+		c.conn.Close()
+	}
+}
@@ -0,0 +1,182 @@
+package dbutil
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//HistBucket 直方图的一个桶, Cum为到这个桶为止的行数占总行数的比例
+type HistBucket struct {
+	Lower string
+	Upper string
+	Cum   float64
+}
+
+//GetTiDBStatsBuckets 获取tidb字段的直方图, 表没有统计信息时为空
+func GetTiDBStatsBuckets(db *sql.DB, dbName, tableName, column string) ([]HistBucket, error) {
+	/*
+		tidb> show stats_buckets where db_name = 'test' and table_name = 't1' and column_name = 'id' and is_index = 0;
+		+---------+------------+----------------+-------------+----------+-----------+-------+---------+-------------+-------------+-----+
+		| Db_name | Table_name | Partition_name | Column_name | Is_index | Bucket_id | Count | Repeats | Lower_Bound | Upper_Bound | Ndv |
+		+---------+------------+----------------+-------------+----------+-----------+-------+---------+-------------+-------------+-----+
+		| test    | t1         |                | id          |        0 |         0 |  4000 |       1 | 1           | 4000        |   0 |
+		| test    | t1         |                | id          |        0 |         1 |  8000 |       1 | 4001        | 8000        |   0 |
+		+---------+------------+----------------+-------------+----------+-----------+-------+---------+-------------+-------------+-----+
+	*/
+	query := fmt.Sprintf("show stats_buckets where db_name = %s and table_name = %s and column_name = %s and is_index = 0",
+		QuoteValue(dbName), QuoteValue(tableName), QuoteValue(column))
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	result, err := scanRowsByName(rows, "PARTITION_NAME", "COUNT", "LOWER_BOUND", "UPPER_BOUND")
+	if err != nil {
+		return nil, err
+	}
+
+	var buckets []HistBucket
+	var counts []float64
+	for _, m := range result {
+		// 分区表每个分区的范围重叠, 只使用整个表的直方图
+		if m["PARTITION_NAME"] != "" && m["PARTITION_NAME"] != "global" {
+			continue
+		}
+		count, err := strconv.ParseFloat(m["COUNT"], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket count %s", m["COUNT"])
+		}
+		buckets = append(buckets, HistBucket{Lower: m["LOWER_BOUND"], Upper: m["UPPER_BOUND"]})
+		counts = append(counts, count)
+	}
+	// Count为累计的行数
+	if len(counts) == 0 || counts[len(counts)-1] <= 0 {
+		return nil, nil
+	}
+	total := counts[len(counts)-1]
+	for i := range buckets {
+		buckets[i].Cum = counts[i] / total
+	}
+	return buckets, nil
+}
+
+//GetMySQLHistogram 获取mysql8字段的直方图(ANALYZE TABLE ... UPDATE HISTOGRAM ON ...), 没有直方图时为空
+func GetMySQLHistogram(db *sql.DB, dbName, tableName, column string) ([]HistBucket, error) {
+	/*
+		mysql> select HISTOGRAM from information_schema.COLUMN_STATISTICS where SCHEMA_NAME = 'test' and TABLE_NAME = 't1' and COLUMN_NAME = 'id';
+		{"buckets": [[1, 4000, 0.5, 4000], [4001, 8000, 1.0, 4000]], "histogram-type": "equi-height", ...}
+		{"buckets": [[1, 0.5], [2, 1.0]], "histogram-type": "singleton", ...}
+	*/
+	query := "select HISTOGRAM from `information_schema`.`COLUMN_STATISTICS` where SCHEMA_NAME = ? and TABLE_NAME = ? and COLUMN_NAME = ?"
+	var histogram sql.NullString
+	err := db.QueryRow(query, dbName, tableName, column).Scan(&histogram)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var h struct {
+		Buckets [][]json.Number `json:"buckets"`
+		Type    string          `json:"histogram-type"`
+	}
+	d := json.NewDecoder(strings.NewReader(histogram.String))
+	// 保留bigint的精度
+	d.UseNumber()
+	if err := d.Decode(&h); err != nil {
+		return nil, fmt.Errorf("histogram of %s is not numeric: %v", column, err)
+	}
+
+	buckets := make([]HistBucket, 0, len(h.Buckets))
+	for _, b := range h.Buckets {
+		var bucket HistBucket
+		var cum json.Number
+		switch {
+		case h.Type == "singleton" && len(b) >= 2:
+			bucket.Lower, bucket.Upper, cum = b[0].String(), b[0].String(), b[1]
+		case h.Type == "equi-height" && len(b) >= 3:
+			bucket.Lower, bucket.Upper, cum = b[0].String(), b[1].String(), b[2]
+		default:
+			return nil, fmt.Errorf("unknown histogram type %s", h.Type)
+		}
+		if bucket.Cum, err = cum.Float64(); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+//IsSingleColumnUnique 字段是否有只包含这一个字段的唯一索引(包括主键), mysql8不在这样的字段上建立直方图
+func IsSingleColumnUnique(db *sql.DB, dbName, tableName, column string) (bool, error) {
+	query := "select count(*) from (select INDEX_NAME from `information_schema`.`STATISTICS` where TABLE_SCHEMA = ? and TABLE_NAME = ? and NON_UNIQUE = 0 " +
+		"group by INDEX_NAME having count(*) = 1 and max(COLUMN_NAME) = ?) u"
+	var cnt int
+	if err := db.QueryRow(query, dbName, tableName, column).Scan(&cnt); err != nil {
+		return false, err
+	}
+	return cnt > 0, nil
+}
+
+//EstimateRangeRows 使用EXPLAIN估算整型字段在[min, max]内的行数, 只读取索引的统计, 不读取表数据
+func EstimateRangeRows(db *sql.DB, dbName, tableName, column string, min, max int64) (int64, error) {
+	/*
+		mysql> explain select 1 from `test`.`t1` where `id` >= 1 and `id` <= 4000;
+		+----+-------------+-------+------------+-------+---------------+---------+---------+------+------+----------+--------------------------+
+		| id | select_type | table | partitions | type  | possible_keys | key     | key_len | ref  | rows | filtered | Extra                    |
+		+----+-------------+-------+------------+-------+---------------+---------+---------+------+------+----------+--------------------------+
+		|  1 | SIMPLE      | t1    | NULL       | range | PRIMARY       | PRIMARY | 4       | NULL | 3998 |   100.00 | Using where; Using index |
+		+----+-------------+-------+------------+-------+---------------+---------+---------+------+------+----------+--------------------------+
+	*/
+	query := fmt.Sprintf("explain select 1 from `%s`.`%s` where `%s` >= ? and `%s` <= ?", dbName, tableName, column, column)
+	return explainRows(db, query, min, max)
+}
+
+//EstimateTableRows 使用EXPLAIN估算表的行数, mysql和tidb都只读取统计信息, 不读取表数据
+func EstimateTableRows(db *sql.DB, dbName, tableName string) (int64, error) {
+	/*
+		tidb> explain select 1 from `test`.`t1`;
+		+-----------------------+----------+-----------+---------------+--------------------------------+
+		| id                    | estRows  | task      | access object | operator info                  |
+		+-----------------------+----------+-----------+---------------+--------------------------------+
+		| Projection_3          | 10000.00 | root      |               | 1->Column#3                    |
+		| └─TableReader_5       | 10000.00 | root      |               | data:TableFullScan_4           |
+		|   └─TableFullScan_4   | 10000.00 | cop[tikv] | table:t1      | keep order:false               |
+		+-----------------------+----------+-----------+---------------+--------------------------------+
+	*/
+	query := fmt.Sprintf("explain select 1 from `%s`.`%s`", dbName, tableName)
+	return explainRows(db, query)
+}
+
+//explainRows 读取EXPLAIN估算的行数, mysql为每一行rows之和, tidb为第一行(根算子)的estRows
+func explainRows(db *sql.DB, query string, args ...interface{}) (int64, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	result, err := scanRowsByName(rows)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, m := range result {
+		if v, ok := m["ESTROWS"]; ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid estRows %s", v)
+			}
+			return int64(f), nil
+		}
+		v, ok := m["ROWS"]
+		if !ok {
+			return 0, errors.New("explain result has no rows column")
+		}
+		n, _ := strconv.ParseInt(v, 10, 64)
+		total += n
+	}
+	return total, nil
+}
@@ -0,0 +1,169 @@
+package dbutil
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+//QuoteColumns 字段名加上反引号并以","拼接
+func QuoteColumns(cols []string) string {
+	quoted := make([]string, 0, len(cols))
+	for _, c := range cols {
+		quoted = append(quoted, fmt.Sprintf("`%s`", c))
+	}
+	return strings.Join(quoted, ",")
+}
+
+//tupleCompare 生成元组比较条件, vals可以是cols的前缀
+func tupleCompare(cols []string, vals []interface{}, op, lastOp string) (string, []interface{}) {
+	/*
+		(a, b) > (1, 2)  ==> (`a` > ? OR (`a` = ? AND `b` > ?)), [1 1 2]
+		(a, b) <= (1, 2) ==> (`a` < ? OR (`a` = ? AND `b` <= ?)), [1 1 2]
+	*/
+	var ors []string
+	var args []interface{}
+	for i := range vals {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, fmt.Sprintf("`%s` = ?", cols[j]))
+			args = append(args, vals[j])
+		}
+		o := op
+		if i == len(vals)-1 {
+			o = lastOp
+		}
+		ands = append(ands, fmt.Sprintf("`%s` %s ?", cols[i], o))
+		args = append(args, vals[i])
+		if len(ands) == 1 {
+			ors = append(ors, ands[0])
+		} else {
+			ors = append(ors, fmt.Sprintf("(%s)", strings.Join(ands, " AND ")))
+		}
+	}
+	if len(ors) == 1 {
+		return ors[0], args
+	}
+	return fmt.Sprintf("(%s)", strings.Join(ors, " OR ")), args
+}
+
+//RangeWhere 生成主键范围(lower, upper]的查询条件, lower或upper为nil时表示不限制
+func RangeWhere(cols []string, lower, upper []interface{}) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if len(lower) > 0 {
+		c, a := tupleCompare(cols, lower, ">", ">")
+		conds = append(conds, c)
+		args = append(args, a...)
+	}
+	if len(upper) > 0 {
+		c, a := tupleCompare(cols, upper, "<", "<=")
+		conds = append(conds, c)
+		args = append(args, a...)
+	}
+	if len(conds) == 0 {
+		return "true", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+//ScanRowValues 读取当前行前n列的值, []byte转换为string, 可以直接作为查询参数绑定
+func ScanRowValues(rows *sql.Rows, n int) ([]interface{}, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(cols))
+	scans := make([]interface{}, len(cols))
+	for i := range vals {
+		scans[i] = &vals[i]
+	}
+	if err = rows.Scan(scans...); err != nil {
+		return nil, err
+	}
+
+	for i, v := range vals {
+		if b, ok := v.([]byte); ok {
+			vals[i] = string(b)
+		}
+	}
+	return vals[:n], nil
+}
+
+//KeyInWhere 生成主键in条件, 单列主键为 a in (?,?), 复合主键为 (a,b) in ((?,?),(?,?))
+func KeyInWhere(cols []string, keys [][]interface{}) (string, []interface{}) {
+	holder := strings.TrimSuffix(strings.Repeat("?,", len(cols)), ",")
+	if len(cols) > 1 {
+		holder = fmt.Sprintf("(%s)", holder)
+	}
+
+	tuples := make([]string, 0, len(keys))
+	args := make([]interface{}, 0, len(keys)*len(cols))
+	for _, k := range keys {
+		tuples = append(tuples, holder)
+		args = append(args, k[:len(cols)]...)
+	}
+	if len(cols) == 1 {
+		return fmt.Sprintf("`%s` in (%s)", cols[0], strings.Join(tuples, ",")), args
+	}
+	return fmt.Sprintf("(%s) in (%s)", QuoteColumns(cols), strings.Join(tuples, ",")), args
+}
+
+//KeyEqualWhere 生成主键等值条件 a=1 and b=2
+func KeyEqualWhere(cols []string, key []interface{}) string {
+	conds := make([]string, 0, len(cols))
+	for i, c := range cols {
+		conds = append(conds, fmt.Sprintf("`%s`=%s", c, QuoteValue(key[i])))
+	}
+	return strings.Join(conds, " and ")
+}
+
+//KeyEqualArgs 生成绑定参数的主键等值条件 `a` = ? AND `b` = ?, 与RangeWhere相同不把值写入sql
+func KeyEqualArgs(cols []string, key []interface{}) (string, []interface{}) {
+	conds := make([]string, 0, len(cols))
+	for _, c := range cols {
+		conds = append(conds, fmt.Sprintf("`%s` = ?", c))
+	}
+	args := make([]interface{}, len(cols))
+	copy(args, key)
+	return strings.Join(conds, " AND "), args
+}
+
+//ScanRowLiterals 读取当前行并转换为sql字面量, 二进制类型使用十六进制, 同时返回原始值
+func ScanRowLiterals(rows *sql.Rows) ([]interface{}, []string, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, err
+	}
+	vals := make([]interface{}, len(types))
+	scans := make([]interface{}, len(types))
+	for i := range vals {
+		scans[i] = &vals[i]
+	}
+	if err = rows.Scan(scans...); err != nil {
+		return nil, nil, err
+	}
+
+	literals := make([]string, 0, len(vals))
+	for i, v := range vals {
+		b, ok := v.([]byte)
+		if ok && isBinaryType(types[i].DatabaseTypeName()) {
+			// 空值不能写成0x, mysql会当作字段名
+			if len(b) == 0 {
+				literals = append(literals, "X''")
+			} else {
+				literals = append(literals, "0x"+hex.EncodeToString(b))
+			}
+			continue
+		}
+		literals = append(literals, QuoteValue(v))
+	}
+
+	for i, v := range vals {
+		if b, ok := v.([]byte); ok {
+			vals[i] = string(b)
+		}
+	}
+	return vals, literals, nil
+}
@@ -0,0 +1,85 @@
+package dbutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeyEqualArgs(t *testing.T) {
+	tests := []struct {
+		cols  []string
+		key   []interface{}
+		where string
+		args  []interface{}
+	}{
+		{[]string{"id"}, []interface{}{1}, "`id` = ?", []interface{}{1}},
+		{[]string{"a", "b"}, []interface{}{"x'y", []byte{0, 0xff}}, "`a` = ? AND `b` = ?", []interface{}{"x'y", []byte{0, 0xff}}},
+		// key比字段多时只使用前面的值
+		{[]string{"a"}, []interface{}{"A", 2}, "`a` = ?", []interface{}{"A"}},
+	}
+	for _, tt := range tests {
+		where, args := KeyEqualArgs(tt.cols, tt.key)
+		if where != tt.where {
+			t.Errorf("%v: got %q, want %q", tt.cols, where, tt.where)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%v: args got %v, want %v", tt.cols, args, tt.args)
+		}
+	}
+}
+
+func TestTupleCompare(t *testing.T) {
+	tests := []struct {
+		cols   []string
+		vals   []interface{}
+		op     string
+		lastOp string
+		where  string
+		args   []interface{}
+	}{
+		{[]string{"a"}, []interface{}{1}, ">", ">", "`a` > ?", []interface{}{1}},
+		{[]string{"a", "b"}, []interface{}{1, 2}, ">", ">", "(`a` > ? OR (`a` = ? AND `b` > ?))", []interface{}{1, 1, 2}},
+		{[]string{"a", "b"}, []interface{}{1, 2}, "<", "<=", "(`a` < ? OR (`a` = ? AND `b` <= ?))", []interface{}{1, 1, 2}},
+		{[]string{"a", "b", "c"}, []interface{}{1, "x", 3}, ">", ">",
+			"(`a` > ? OR (`a` = ? AND `b` > ?) OR (`a` = ? AND `b` = ? AND `c` > ?))", []interface{}{1, 1, "x", 1, "x", 3}},
+		// 值为字段的前缀时只比较前面的字段
+		{[]string{"a", "b"}, []interface{}{"k"}, "<", "<=", "`a` <= ?", []interface{}{"k"}},
+	}
+	for _, tt := range tests {
+		where, args := tupleCompare(tt.cols, tt.vals, tt.op, tt.lastOp)
+		if where != tt.where {
+			t.Errorf("%v %s %v: got %q, want %q", tt.cols, tt.lastOp, tt.vals, where, tt.where)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%v %s %v: args got %v, want %v", tt.cols, tt.lastOp, tt.vals, args, tt.args)
+		}
+	}
+}
+
+func TestRangeWhere(t *testing.T) {
+	tests := []struct {
+		name  string
+		cols  []string
+		lower []interface{}
+		upper []interface{}
+		where string
+		args  []interface{}
+	}{
+		{"unbounded", []string{"id"}, nil, nil, "true", nil},
+		{"first chunk", []string{"id"}, nil, []interface{}{10}, "`id` <= ?", []interface{}{10}},
+		{"last chunk", []string{"id"}, []interface{}{10}, nil, "`id` > ?", []interface{}{10}},
+		{"middle chunk", []string{"id"}, []interface{}{10}, []interface{}{20}, "`id` > ? AND `id` <= ?", []interface{}{10, 20}},
+		{"composite key", []string{"a", "b"}, []interface{}{1, "x"}, []interface{}{2, "y"},
+			"(`a` > ? OR (`a` = ? AND `b` > ?)) AND (`a` < ? OR (`a` = ? AND `b` <= ?))", []interface{}{1, 1, "x", 2, 2, "y"}},
+		{"binary key is bound", []string{"k"}, []interface{}{"a'\x00"}, nil, "`k` > ?", []interface{}{"a'\x00"}},
+	}
+	for _, tt := range tests {
+		where, args := RangeWhere(tt.cols, tt.lower, tt.upper)
+		if where != tt.where {
+			t.Errorf("%s: got %q, want %q", tt.name, where, tt.where)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%s: args got %v, want %v", tt.name, args, tt.args)
+		}
+	}
+}